@@ -9,8 +9,12 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
-	"strconv"
 	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
 
 	"github.com/tj/gobinaries"
 )
@@ -37,9 +41,31 @@ var environWhitelist = []string{
 	"TMPDIR",
 }
 
+// recordedVariables are the environment variables recorded in the details of
+// builds, selecting the platform, flags, module downloads and toolchain.
+var recordedVariables = []string{
+	"CGO_ENABLED",
+	"GOOS",
+	"GOARCH",
+	"GOARM",
+	"GOFLAGS",
+	"GOPROXY",
+	"GOSUMDB",
+	"GOTOOLCHAIN",
+}
+
+// goDefaults are the Go defaults of the recorded environment variables.
+var goDefaults = map[string]string{
+	"GOPROXY": "https://proxy.golang.org,direct",
+	"GOSUMDB": "sum.golang.org",
+}
+
 // ErrNotExecutable is returned when the package path provided does not produce a binary.
 var ErrNotExecutable = errors.New("not executable")
 
+// ErrModuleMismatch is returned when the package path provided is not part of the module.
+var ErrModuleMismatch = errors.New("package not in module")
+
 // Error represents a build error.
 type Error struct {
 	err    error
@@ -51,49 +77,157 @@ func (e Error) Error() string {
 	return fmt.Sprintf("%s: %s", e.err.Error(), e.stderr)
 }
 
+// Builder is a Go binary builder using the local Go toolchain.
+type Builder struct {
+	// Cache is an optional managed module cache, otherwise the default
+	// module cache is used and grows without bound.
+	Cache *Cache
+
+	// Toolchain is an optional Go toolchain version such as "go1.21.5", used
+	// for every build regardless of the installed Go version, so that builds
+	// are reproducible. The go command downloads the toolchain when necessary.
+	Toolchain string
+
+	// Proxy is the module proxy list used as GOPROXY, defaulting to
+	// "https://proxy.golang.org,direct".
+	Proxy string
+
+	// SumDB is the checksum database used as GOSUMDB, which verifies module
+	// downloads, defaulting to "sum.golang.org". Verification is disabled with "off".
+	SumDB string
+
+	// Private is a list of module path prefixes used as GOPRIVATE, which
+	// are downloaded directly, and not verified by the checksum database.
+	Private string
+
+	// NoProxy is a list of module path prefixes used as GONOPROXY,
+	// which are downloaded directly, defaulting to Private.
+	NoProxy string
+
+	// NoSumDB is a list of module path prefixes used as GONOSUMDB, which
+	// are not verified by the checksum database, defaulting to Private.
+	NoSumDB string
+
+	// Netrc is an optional path of a netrc file with credentials for private
+	// module hosts, used as NETRC by the go command, such as for a private proxy.
+	Netrc string
+
+	// GitCredentialHelper is an optional git credential helper such as
+	// "store --file=/etc/gobinaries/git-credentials", used by git when
+	// private modules are downloaded directly from their repositories.
+	// It is configured with GIT_CONFIG_COUNT, which requires git 2.31 or later.
+	GitCredentialHelper string
+
+	mu        sync.Mutex
+	supported map[string]bool
+	version   string
+}
+
+// buildFlags are the `go build` flags, which omit file system paths from binaries.
+var buildFlags = []string{"-mod=mod", "-trimpath"}
+
 // Write a package binary to w.
-func Write(w io.Writer, bin gobinaries.Binary) error {
-	dir, err := os.UserHomeDir()
+func (b *Builder) Write(w io.Writer, bin gobinaries.Binary) error {
+	_, err := b.WriteBuild(w, bin)
+	return err
+}
+
+// WriteBuild writes a package binary to w, returning the details of the build.
+// Builds are reproducible, producing identical binaries for identical toolchains.
+func (b *Builder) WriteBuild(w io.Writer, bin gobinaries.Binary) (gobinaries.Build, error) {
+	build := gobinaries.Build{
+		Flags:        append([]string(nil), buildFlags...),
+		Ldflags:      ldflags(bin),
+		Env:          recordedEnviron(b.buildEnviron(bin)),
+		Reproducible: b.Toolchain != "",
+		Started:      time.Now(),
+	}
+
+	version, err := b.goVersion()
+	if err != nil {
+		return build, fmt.Errorf("checking go version: %w", err)
+	}
+	build.Toolchain = version
+
+	err = b.write(w, bin, &build)
+	build.Finished = time.Now()
+	return build, err
+}
+
+// write a package binary to w, adding the details of the build to build.
+func (b *Builder) write(w io.Writer, bin gobinaries.Binary, build *gobinaries.Build) error {
+	// create a workspace for this build, so that concurrent
+	// builds never share the same go.mod or go.sum
+	dir, err := ioutil.TempDir("", "gobinary")
 	if err != nil {
-		return fmt.Errorf("user home dir: %w", err)
+		return fmt.Errorf("creating workspace: %w", err)
 	}
+	defer os.RemoveAll(dir)
 
-	// remove the old go.mod if there is one
-	err = os.Remove(filepath.Join(dir, "go.mod"))
-	if err != nil && !os.IsNotExist(err) {
-		return fmt.Errorf("removing go.mod: %w", err)
+	// pin the module versions used while building, preventing their eviction
+	dep := normalizeModuleDep(bin)
+	if b.Cache != nil {
+		cb := b.Cache.acquire(dep)
+		defer b.Cache.release(cb)
 	}
 
 	// create a go.mod file, this is currently required
 	// in order to install a package with a specified version
-	err = addModule(dir)
+	err = b.addModule(dir)
 	if err != nil {
 		return fmt.Errorf("initializing module: %w", err)
 	}
 
 	// add the dependency
-	err = addModuleDep(dir, normalizeModuleDep(bin))
+	err = b.addModuleDep(dir, dep)
 	if err != nil {
 		return fmt.Errorf("adding dependency: %w", err)
 	}
 
-	// tmpfile for the binary
-	dst, err := tempFilename()
+	// ensure the package is provided by the module, otherwise
+	// the latest version of another module would be built
+	err = b.checkModule(dir, bin)
 	if err != nil {
-		return fmt.Errorf("creating tempfile: %w", err)
+		return err
+	}
+
+	// mark the modules listed as used before compiling, pinning the
+	// module versions which were already in the cache
+	if b.Cache != nil {
+		err = b.Cache.touch(filepath.Join(dir, "go.sum"))
+		if err != nil {
+			return fmt.Errorf("touching cache: %w", err)
+		}
 	}
 
 	// build the binary
-	err = buildBinary(dir, dst, bin)
+	dst := filepath.Join(dir, "binary")
+	err = b.buildBinary(dir, dst, bin)
 	if err != nil {
 		return fmt.Errorf("building: %w", err)
 	}
 
+	// record the hashes of the modules used
+	build.GoSum, err = readGoSum(filepath.Join(dir, "go.sum"))
+	if err != nil {
+		return fmt.Errorf("reading go.sum: %w", err)
+	}
+	build.Sum = moduleSum(build.GoSum, dep)
+
+	// mark the modules used as recently used
+	if b.Cache != nil {
+		err = b.Cache.touch(filepath.Join(dir, "go.sum"))
+		if err != nil {
+			return fmt.Errorf("touching cache: %w", err)
+		}
+	}
+
 	// check permissions and copy it to w
 	f, err := os.Open(dst)
 	if err != nil {
 		return fmt.Errorf("opening: %w", err)
 	}
+	defer f.Close()
 
 	info, err := f.Stat()
 	if err != nil {
@@ -109,25 +243,9 @@ func Write(w io.Writer, bin gobinaries.Binary) error {
 		return fmt.Errorf("copying: %w", err)
 	}
 
-	err = f.Close()
-	if err != nil {
-		return fmt.Errorf("closing: %w", err)
-	}
-
-	err = os.Remove(dst)
-	if err != nil {
-		return fmt.Errorf("removing tempfile: %w", err)
-	}
-
 	return nil
 }
 
-// ClearCache removes the module cache.
-func ClearCache() error {
-	cmd := exec.Command("go", "clean", "--modcache")
-	return cmd.Run()
-}
-
 // isExecutable returns true if the exec bit is set for u/g/o.
 func isExecutable(mode os.FileMode) bool {
 	return mode.Perm()&0111 == 0111
@@ -136,59 +254,131 @@ func isExecutable(mode os.FileMode) bool {
 // addModule initializes a new go module in the given dir. This is apparently
 // necessary to build using Go modules since `go build` does not support
 // semver, awkward UX but oh well.
-func addModule(dir string) error {
+func (b *Builder) addModule(dir string) error {
 	cmd := exec.Command("go", "mod", "init", "github.com/gobinary")
-	cmd.Env = environ()
+	cmd.Env = b.environ()
 	cmd.Env = append(cmd.Env, "GO111MODULE=on")
 	cmd.Dir = dir
 	return command(cmd)
 }
 
-// getMajorVersion tries to detect the major version of the package.
-func getMajorVersion(tag string) (int, error) {
-	major := strings.Split(tag, ".")[0]
-	if len(major) < 1 {
-		return 0, fmt.Errorf("invalid major version")
-	}
-	return strconv.Atoi(major[1:])
-}
-
-// normalizeModuleDep returns a normalized module dependency.
+// normalizeModuleDep returns a normalized module dependency, where the
+// "/vN" suffix is added to the module path when the version requires it,
+// unless it is a "+incompatible" version of a module without go.mod file.
 func normalizeModuleDep(bin gobinaries.Binary) string {
 	mod := bin.Module
 	version := bin.Version
-	var dep string
-	major, err := getMajorVersion(version)
-	if err == nil && major > 1 {
-		dep = fmt.Sprintf("%s/v%d@%s", mod, major, version)
-	} else {
-		dep = fmt.Sprintf("%s@%s", mod, version)
+
+	_, pathMajor, ok := module.SplitPathVersion(mod)
+	if ok && pathMajor == "" && module.CheckPathMajor(version, pathMajor) != nil {
+		mod += "/" + semver.Major(version)
 	}
-	return dep
+
+	return mod + "@" + version
 }
 
 // addModuleDep creates a module dependency.
-func addModuleDep(dir, dep string) error {
+func (b *Builder) addModuleDep(dir, dep string) error {
 	cmd := exec.Command("go", "mod", "edit", "-require", dep)
-	cmd.Env = environ()
+	cmd.Env = b.environ()
 	cmd.Env = append(cmd.Env, "GO111MODULE=on")
 	cmd.Dir = dir
 	return command(cmd)
 }
 
+// checkModule returns an error when the package is not provided by the module dependency.
+func (b *Builder) checkModule(dir string, bin gobinaries.Binary) error {
+	var w strings.Builder
+	cmd := exec.Command("go", "list", "-mod=mod", "-f", "{{with .Module}}{{.Path}}{{end}}", bin.Path)
+	cmd.Env = b.buildEnviron(bin)
+	cmd.Dir = dir
+	cmd.Stdout = &w
+	err := command(cmd)
+	if err != nil {
+		return fmt.Errorf("listing package: %w", err)
+	}
+
+	dep := normalizeModuleDep(bin)
+	mod := dep[:strings.LastIndex(dep, "@")]
+	if found := strings.TrimSpace(w.String()); found != mod {
+		return fmt.Errorf("%w: %s is provided by %q, not %q", ErrModuleMismatch, bin.Path, found, mod)
+	}
+
+	return nil
+}
+
 // buildBinary performs a `go build` and outputs the binary to dst.
-func buildBinary(dir, dst string, bin gobinaries.Binary) error {
-	ldflags := fmt.Sprintf("-X main.version=%s", bin.Version)
-	cmd := exec.Command("go", "build", "-o", dst, "-ldflags", ldflags, bin.Path)
-	cmd.Env = environ()
-	cmd.Env = append(cmd.Env, "CGO_ENABLED=0")
-	cmd.Env = append(cmd.Env, "GO111MODULE=on")
-	cmd.Env = append(cmd.Env, "GOOS="+bin.OS)
-	cmd.Env = append(cmd.Env, "GOARCH="+bin.Arch)
+func (b *Builder) buildBinary(dir, dst string, bin gobinaries.Binary) error {
+	args := append([]string{"build"}, buildFlags...)
+	args = append(args, "-o", dst, "-ldflags", ldflags(bin), bin.Path)
+	cmd := exec.Command("go", args...)
+	cmd.Env = b.buildEnviron(bin)
 	cmd.Dir = dir
 	return command(cmd)
 }
 
+// readGoSum returns the lines of a go.sum file.
+func readGoSum(path string) ([]string, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var lines []string
+	for _, line := range strings.Split(string(b), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+
+	return lines, nil
+}
+
+// moduleSum returns the hash of the module dependency such as "github.com/tj/triage@v1.0.0"
+// from the lines of a go.sum file, or an empty string when it is missing.
+func moduleSum(lines []string, dep string) string {
+	i := strings.LastIndex(dep, "@")
+	mod, version := dep[:i], dep[i+1:]
+
+	for _, line := range lines {
+		f := strings.Fields(line)
+		if len(f) == 3 && f[0] == mod && f[1] == version {
+			return f[2]
+		}
+	}
+
+	return ""
+}
+
+// ldflags returns the linker flags of the binary, setting its version
+// and omitting the build ID so that builds are reproducible.
+func ldflags(bin gobinaries.Binary) string {
+	return fmt.Sprintf("-X main.version=%s -buildid=", bin.Version)
+}
+
+// goVersion returns the version of the Go toolchain used for builds,
+// checked once and cached.
+func (b *Builder) goVersion() (string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.version != "" {
+		return b.version, nil
+	}
+
+	var w strings.Builder
+	cmd := exec.Command("go", "env", "GOVERSION")
+	cmd.Env = b.environ()
+	cmd.Stdout = &w
+	err := command(cmd)
+	if err != nil {
+		return "", err
+	}
+
+	b.version = strings.TrimSpace(w.String())
+	return b.version, nil
+}
+
 // command executes a command and capture stderr.
 func command(cmd *exec.Cmd) error {
 	var w strings.Builder
@@ -203,17 +393,6 @@ func command(cmd *exec.Cmd) error {
 	return nil
 }
 
-// tempFilename returns a new temporary file name.
-func tempFilename() (string, error) {
-	f, err := ioutil.TempFile(os.TempDir(), "gobinary")
-	if err != nil {
-		return "", err
-	}
-	defer f.Close()
-	defer os.Remove(f.Name())
-	return f.Name(), nil
-}
-
 // environ returns the environment variables for Go sub-commands.
 func environ() (env []string) {
 	for _, name := range environWhitelist {
@@ -221,3 +400,97 @@ func environ() (env []string) {
 	}
 	return
 }
+
+// buildEnviron returns the environment variables for building the binary.
+func (b *Builder) buildEnviron(bin gobinaries.Binary) []string {
+	env := b.environ()
+	env = append(env, "GO111MODULE=on")
+	env = append(env, platformEnviron(bin)...)
+	return env
+}
+
+// recordedEnviron returns the recorded variables of a build environment, where
+// the last value of a variable wins as it does for commands, or its Go default.
+func recordedEnviron(env []string) (recorded []string) {
+	values := make(map[string]string)
+	for _, v := range env {
+		parts := strings.SplitN(v, "=", 2)
+		if len(parts) == 2 {
+			values[parts[0]] = parts[1]
+		}
+	}
+
+	for _, name := range recordedVariables {
+		v := values[name]
+		if v == "" {
+			v = goDefaults[name]
+		}
+
+		if v != "" {
+			recorded = append(recorded, name+"="+v)
+		}
+	}
+
+	return
+}
+
+// platformEnviron returns the environment variables selecting the binary's platform.
+func platformEnviron(bin gobinaries.Binary) []string {
+	env := []string{"CGO_ENABLED=0", "GOOS=" + bin.OS}
+
+	// translate ARM variants such as "armv7" to GOARCH and GOARM
+	goarch, goarm := splitArch(bin.Arch)
+	env = append(env, "GOARCH="+goarch)
+	if goarm != "" {
+		env = append(env, "GOARM="+goarm)
+	}
+
+	return env
+}
+
+// environ returns the environment variables for the builder's Go sub-commands.
+func (b *Builder) environ() []string {
+	env := environ()
+
+	// use the managed module cache, writable so that it may be pruned
+	if b.Cache != nil {
+		env = append(env, "GOMODCACHE="+b.Cache.Dir)
+		env = append(env, "GOFLAGS=-modcacherw")
+	}
+
+	// module proxy and checksum database, which
+	// otherwise default to the Go defaults
+	for _, v := range [][2]string{
+		{"GOPROXY", b.Proxy},
+		{"GOSUMDB", b.SumDB},
+		{"GOPRIVATE", b.Private},
+		{"GONOPROXY", b.NoProxy},
+		{"GONOSUMDB", b.NoSumDB},
+	} {
+		if v[1] != "" {
+			env = append(env, v[0]+"="+v[1])
+		}
+	}
+
+	// credentials of private modules, where git is configured through
+	// the environment so that the user's git config is left untouched
+	if b.Netrc != "" {
+		env = append(env, "NETRC="+b.Netrc)
+	}
+
+	if b.GitCredentialHelper != "" {
+		env = append(env, "GIT_CONFIG_COUNT=1")
+		env = append(env, "GIT_CONFIG_KEY_0=credential.helper")
+		env = append(env, "GIT_CONFIG_VALUE_0="+b.GitCredentialHelper)
+	}
+
+	// use the pinned toolchain, otherwise the installed toolchain
+	// instead of switching to the toolchain a module requires
+	if b.Toolchain != "" {
+		env = append(env, "GOTOOLCHAIN="+b.Toolchain)
+	} else {
+		env = append(env, "GOTOOLCHAIN=local")
+	}
+
+	return env
+}
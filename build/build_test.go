@@ -0,0 +1,449 @@
+package build
+
+import (
+	"archive/zip"
+	"bytes"
+	"debug/elf"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/tj/assert"
+
+	"github.com/tj/gobinaries"
+)
+
+// TestMain points Go sub-commands at a local module proxy.
+func TestMain(m *testing.M) {
+	dir, err := ioutil.TempDir("", "gobinaries-build")
+	if err != nil {
+		panic(err)
+	}
+
+	environWhitelist = append(environWhitelist, "GOPROXY", "GOSUMDB", "GOFLAGS", "GOMODCACHE")
+	environMap["GOPROXY"] = "file://" + filepath.ToSlash(filepath.Join(dir, "proxy"))
+	environMap["GOSUMDB"] = "off"
+	environMap["GOFLAGS"] = "-modcacherw"
+	environMap["GOMODCACHE"] = filepath.Join(dir, "modcache")
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// addFakeModule adds a module with a single main package printing message to the local proxy.
+func addFakeModule(t testing.TB, mod, version, message string) {
+	addFakeModuleFiles(t, mod, version, map[string]string{
+		"go.mod":  fmt.Sprintf("module %s\n", mod),
+		"main.go": mainFile(message),
+	})
+}
+
+// addFakeModuleFiles adds a module with the given files to the local proxy,
+// the go.mod file is synthesized when missing as it is for legacy modules.
+func addFakeModuleFiles(t testing.TB, mod, version string, files map[string]string) {
+	dir := filepath.Join(filepath.FromSlash(environMap["GOPROXY"][len("file://"):]), escapePath(mod), "@v")
+	err := os.MkdirAll(dir, 0755)
+	assert.NoError(t, err)
+
+	gomod, ok := files["go.mod"]
+	if !ok {
+		gomod = fmt.Sprintf("module %s\n", mod)
+	}
+
+	var buf bytes.Buffer
+	z := zip.NewWriter(&buf)
+	for name, body := range files {
+		w, err := z.Create(mod + "@" + version + "/" + name)
+		assert.NoError(t, err)
+		_, err = w.Write([]byte(body))
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, z.Close())
+
+	info := fmt.Sprintf(`{"Version":%q,"Time":"2020-01-01T00:00:00Z"}`, version)
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "list"), []byte(version+"\n"), 0644))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, version+".info"), []byte(info), 0644))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, version+".mod"), []byte(gomod), 0644))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, version+".zip"), buf.Bytes(), 0644))
+}
+
+// mainFile returns a main package printing message.
+func mainFile(message string) string {
+	return fmt.Sprintf("package main\n\nfunc main() {\n\tprintln(%q)\n}\n", message)
+}
+
+// Test building packages.
+func TestBuilder_Write(t *testing.T) {
+	t.Run("single", func(t *testing.T) {
+		addFakeModule(t, "example.com/single", "v1.0.0", "single")
+
+		var buf bytes.Buffer
+		var b Builder
+		err := b.Write(&buf, gobinaries.Binary{
+			Path:    "example.com/single",
+			Module:  "example.com/single",
+			Version: "v1.0.0",
+			OS:      runtime.GOOS,
+			Arch:    runtime.GOARCH,
+		})
+
+		assert.NoError(t, err)
+		assert.True(t, bytes.Contains(buf.Bytes(), []byte("single")))
+	})
+
+	t.Run("pseudo-version", func(t *testing.T) {
+		version := "v0.0.0-20200409193015-0123456789ab"
+		addFakeModule(t, "example.com/pseudo", version, "pseudo")
+
+		var buf bytes.Buffer
+		var b Builder
+		err := b.Write(&buf, gobinaries.Binary{
+			Path:    "example.com/pseudo",
+			Module:  "example.com/pseudo",
+			Version: version,
+			OS:      runtime.GOOS,
+			Arch:    runtime.GOARCH,
+		})
+
+		assert.NoError(t, err)
+		assert.True(t, bytes.Contains(buf.Bytes(), []byte("pseudo")))
+	})
+
+	t.Run("arm variant", func(t *testing.T) {
+		addFakeModule(t, "example.com/arm", "v1.0.0", "arm")
+
+		var buf bytes.Buffer
+		var b Builder
+		err := b.Write(&buf, gobinaries.Binary{
+			Path:    "example.com/arm",
+			Module:  "example.com/arm",
+			Version: "v1.0.0",
+			OS:      "linux",
+			Arch:    "armv6",
+		})
+
+		assert.NoError(t, err)
+
+		f, err := elf.NewFile(bytes.NewReader(buf.Bytes()))
+		assert.NoError(t, err)
+		assert.Equal(t, elf.EM_ARM, f.Machine)
+	})
+
+	t.Run("concurrent", func(t *testing.T) {
+		var b Builder
+		var wg sync.WaitGroup
+		names := []string{"alpha", "bravo", "charlie", "delta"}
+		results := make([]bytes.Buffer, len(names))
+		errs := make([]error, len(names))
+
+		for _, name := range names {
+			addFakeModule(t, "example.com/"+name, "v1.0.0", "hello from "+name)
+		}
+
+		for i, name := range names {
+			wg.Add(1)
+			go func(i int, name string) {
+				defer wg.Done()
+				errs[i] = b.Write(&results[i], gobinaries.Binary{
+					Path:    "example.com/" + name,
+					Module:  "example.com/" + name,
+					Version: "v1.0.0",
+					OS:      runtime.GOOS,
+					Arch:    runtime.GOARCH,
+				})
+			}(i, name)
+		}
+
+		wg.Wait()
+
+		for i, name := range names {
+			assert.NoError(t, errs[i], name)
+			for j, other := range names {
+				contains := bytes.Contains(results[i].Bytes(), []byte("hello from "+other))
+				assert.Equal(t, i == j, contains, "%s binary containing %q", name, other)
+			}
+		}
+	})
+}
+
+// Test building packages of major version, legacy and nested modules.
+func TestBuilder_Write_modules(t *testing.T) {
+	addFakeModuleFiles(t, "example.com/fixture/zero", "v0.1.0", map[string]string{
+		"go.mod":  "module example.com/fixture/zero\n",
+		"main.go": mainFile("fixture v0"),
+	})
+
+	addFakeModuleFiles(t, "example.com/fixture/one", "v1.2.0", map[string]string{
+		"go.mod":          "module example.com/fixture/one\n",
+		"cmd/one/main.go": mainFile("fixture v1"),
+	})
+
+	addFakeModuleFiles(t, "example.com/fixture/two/v2", "v2.1.0", map[string]string{
+		"go.mod":          "module example.com/fixture/two/v2\n",
+		"cmd/two/main.go": mainFile("fixture v2"),
+	})
+
+	addFakeModuleFiles(t, "example.com/fixture/legacy", "v2.0.0+incompatible", map[string]string{
+		"main.go": mainFile("fixture incompatible"),
+	})
+
+	addFakeModuleFiles(t, "example.com/fixture/mono", "v1.0.0", map[string]string{
+		"go.mod":  "module example.com/fixture/mono\n",
+		"mono.go": "package mono\n",
+	})
+
+	addFakeModuleFiles(t, "example.com/fixture/mono/tools", "v1.1.0", map[string]string{
+		"go.mod":           "module example.com/fixture/mono/tools\n",
+		"cmd/tool/main.go": mainFile("fixture nested"),
+	})
+
+	addFakeModuleFiles(t, "example.com/fixture/mono/tools/v2", "v2.0.0", map[string]string{
+		"go.mod":           "module example.com/fixture/mono/tools/v2\n",
+		"cmd/tool/main.go": mainFile("fixture nested v2"),
+	})
+
+	cases := []struct {
+		name    string
+		path    string
+		mod     string
+		version string
+		message string
+	}{
+		{"v0", "example.com/fixture/zero", "example.com/fixture/zero", "v0.1.0", "fixture v0"},
+		{"v1", "example.com/fixture/one/cmd/one", "example.com/fixture/one", "v1.2.0", "fixture v1"},
+		{"v2", "example.com/fixture/two/v2/cmd/two", "example.com/fixture/two/v2", "v2.1.0", "fixture v2"},
+		{"v2 without suffix", "example.com/fixture/two/v2/cmd/two", "example.com/fixture/two", "v2.1.0", "fixture v2"},
+		{"incompatible", "example.com/fixture/legacy", "example.com/fixture/legacy", "v2.0.0+incompatible", "fixture incompatible"},
+		{"nested", "example.com/fixture/mono/tools/cmd/tool", "example.com/fixture/mono/tools", "v1.1.0", "fixture nested"},
+		{"nested v2", "example.com/fixture/mono/tools/v2/cmd/tool", "example.com/fixture/mono/tools", "v2.0.0", "fixture nested v2"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			var b Builder
+			err := b.Write(&buf, gobinaries.Binary{
+				Path:    c.path,
+				Module:  c.mod,
+				Version: c.version,
+				OS:      runtime.GOOS,
+				Arch:    runtime.GOARCH,
+			})
+
+			assert.NoError(t, err)
+			assert.True(t, bytes.Contains(buf.Bytes(), []byte(c.message)))
+		})
+	}
+
+	t.Run("package outside of module", func(t *testing.T) {
+		var buf bytes.Buffer
+		var b Builder
+		err := b.Write(&buf, gobinaries.Binary{
+			Path:    "example.com/fixture/mono/tools/cmd/tool",
+			Module:  "example.com/fixture/mono",
+			Version: "v1.0.0",
+			OS:      runtime.GOOS,
+			Arch:    runtime.GOARCH,
+		})
+
+		assert.True(t, errors.Is(err, ErrModuleMismatch), "error %v", err)
+		assert.Equal(t, 0, buf.Len())
+	})
+}
+
+// Test module dependency normalization.
+func TestNormalizeModuleDep(t *testing.T) {
+	cases := []struct {
+		version  string
+		expected string
+	}{
+		{"v1.2.0", "github.com/tj/triage@v1.2.0"},
+		{"v2.1.0", "github.com/tj/triage/v2@v2.1.0"},
+		{"v0.0.0-20200409193015-0123456789ab", "github.com/tj/triage@v0.0.0-20200409193015-0123456789ab"},
+		{"v1.2.4-0.20200409193015-0123456789ab", "github.com/tj/triage@v1.2.4-0.20200409193015-0123456789ab"},
+		{"v2.0.0-20200409193015-0123456789ab", "github.com/tj/triage/v2@v2.0.0-20200409193015-0123456789ab"},
+		{"v2.0.0+incompatible", "github.com/tj/triage@v2.0.0+incompatible"},
+	}
+
+	for _, c := range cases {
+		dep := normalizeModuleDep(gobinaries.Binary{
+			Module:  "github.com/tj/triage",
+			Version: c.version,
+		})
+		assert.Equal(t, c.expected, dep)
+	}
+
+	t.Run("with major version suffix", func(t *testing.T) {
+		dep := normalizeModuleDep(gobinaries.Binary{
+			Module:  "github.com/tj/triage/v2",
+			Version: "v2.1.0",
+		})
+		assert.Equal(t, "github.com/tj/triage/v2@v2.1.0", dep)
+	})
+
+	t.Run("nested module", func(t *testing.T) {
+		dep := normalizeModuleDep(gobinaries.Binary{
+			Module:  "github.com/tj/mono/tools/v3",
+			Version: "v3.0.0",
+		})
+		assert.Equal(t, "github.com/tj/mono/tools/v3@v3.0.0", dep)
+	})
+}
+
+// Test reproducing builds on a fresh host.
+func TestBuilder_WriteBuild_reproducible(t *testing.T) {
+	addFakeModule(t, "example.com/reproducible", "v1.0.0", "reproducible")
+
+	bin := gobinaries.Binary{
+		Path:    "example.com/reproducible",
+		Module:  "example.com/reproducible",
+		Version: "v1.0.0",
+		OS:      "linux",
+		Arch:    "amd64",
+	}
+
+	dir, err := ioutil.TempDir("", "gobinaries-reproducible")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// build with distinct module and build caches
+	write := func(name string) ([]byte, gobinaries.Build) {
+		home := environMap["HOME"]
+		environMap["HOME"] = filepath.Join(dir, name, "home")
+		defer func() { environMap["HOME"] = home }()
+
+		b := Builder{
+			Cache: &Cache{Dir: filepath.Join(dir, name, "modcache")},
+		}
+
+		var buf bytes.Buffer
+		build, err := b.WriteBuild(&buf, bin)
+		assert.NoError(t, err)
+		return buf.Bytes(), build
+	}
+
+	a, build := write("a")
+	b, _ := write("b")
+	assert.True(t, bytes.Equal(a, b), "binaries should be identical")
+	assert.False(t, bytes.Contains(a, []byte(dir)), "binaries should not contain paths")
+
+	assert.True(t, strings.HasPrefix(build.Toolchain, "go"))
+	assert.Equal(t, []string{"-mod=mod", "-trimpath"}, build.Flags)
+	assert.Equal(t, "-X main.version=v1.0.0 -buildid=", build.Ldflags)
+	assert.True(t, strings.HasPrefix(build.Sum, "h1:"), build.Sum)
+	assert.Equal(t, []string{
+		"CGO_ENABLED=0",
+		"GOOS=linux",
+		"GOARCH=amd64",
+		"GOFLAGS=-modcacherw",
+		"GOPROXY=" + environMap["GOPROXY"],
+		"GOSUMDB=off",
+		"GOTOOLCHAIN=local",
+	}, build.Env)
+	assert.False(t, build.Reproducible)
+	assert.False(t, build.Finished.Before(build.Started))
+}
+
+// Test verifying module downloads with the checksum database.
+func TestBuilder_Write_sumdb(t *testing.T) {
+	addFakeModule(t, "example.com/verified", "v1.0.0", "verified")
+
+	bin := gobinaries.Binary{
+		Path:    "example.com/verified",
+		Module:  "example.com/verified",
+		Version: "v1.0.0",
+		OS:      runtime.GOOS,
+		Arch:    runtime.GOARCH,
+	}
+
+	dir, err := ioutil.TempDir("", "gobinaries-sumdb")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	t.Run("verified", func(t *testing.T) {
+		b := Builder{
+			Cache: &Cache{Dir: filepath.Join(dir, "verified")},
+			SumDB: "sum.example.com",
+		}
+
+		err := b.Write(ioutil.Discard, bin)
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "verifying module")
+	})
+
+	t.Run("excluded", func(t *testing.T) {
+		b := Builder{
+			Cache:   &Cache{Dir: filepath.Join(dir, "excluded")},
+			SumDB:   "sum.example.com",
+			NoSumDB: "example.com/verified",
+		}
+
+		build, err := b.WriteBuild(ioutil.Discard, bin)
+		assert.NoError(t, err)
+		assert.Len(t, build.GoSum, 2)
+		assert.True(t, strings.HasPrefix(build.GoSum[0], "example.com/verified v1.0.0 h1:"), build.GoSum[0])
+		assert.True(t, strings.HasPrefix(build.GoSum[1], "example.com/verified v1.0.0/go.mod h1:"), build.GoSum[1])
+		assert.Equal(t, strings.Fields(build.GoSum[0])[2], build.Sum)
+	})
+}
+
+// Test recording the build environment.
+func TestRecordedEnviron(t *testing.T) {
+	env := recordedEnviron([]string{
+		"PATH=/usr/bin",
+		"GOPROXY=",
+		"GOOS=linux",
+		"GOARCH=arm",
+		"GOARM=7",
+		"GOTOOLCHAIN=go1.21.5",
+		"GOTOOLCHAIN=local",
+	})
+
+	assert.Equal(t, []string{
+		"GOOS=linux",
+		"GOARCH=arm",
+		"GOARM=7",
+		"GOPROXY=https://proxy.golang.org,direct",
+		"GOSUMDB=sum.golang.org",
+		"GOTOOLCHAIN=local",
+	}, env)
+}
+
+// Test configuring the module proxy and checksum database.
+func TestBuilder_environ(t *testing.T) {
+	b := Builder{
+		Proxy:   "https://proxy.example.com",
+		SumDB:   "sum.golang.org",
+		Private: "example.com/private",
+	}
+
+	env := b.environ()
+	assert.Contains(t, env, "GOPROXY=https://proxy.example.com")
+	assert.Contains(t, env, "GOSUMDB=sum.golang.org")
+	assert.Contains(t, env, "GOPRIVATE=example.com/private")
+	assert.NotContains(t, env, "GONOSUMDB=")
+	assert.Contains(t, env, "GOTOOLCHAIN=local")
+	assert.NotContains(t, env, "GIT_CONFIG_COUNT=1")
+
+	t.Run("credentials", func(t *testing.T) {
+		b := Builder{
+			Private:             "github.com/acme",
+			Netrc:               "/etc/gobinaries/netrc",
+			GitCredentialHelper: "store --file=/etc/gobinaries/git-credentials",
+		}
+
+		env := b.environ()
+		assert.Contains(t, env, "GOPRIVATE=github.com/acme")
+		assert.Contains(t, env, "NETRC=/etc/gobinaries/netrc")
+		assert.Contains(t, env, "GIT_CONFIG_COUNT=1")
+		assert.Contains(t, env, "GIT_CONFIG_KEY_0=credential.helper")
+		assert.Contains(t, env, "GIT_CONFIG_VALUE_0=store --file=/etc/gobinaries/git-credentials")
+	})
+}
@@ -0,0 +1,318 @@
+package build
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// Cache is a Go module cache with a size budget. Module versions
+// are evicted in least-recently-used order when the cache exceeds
+// its budget, instead of removing the entire cache.
+type Cache struct {
+	// Dir is the module cache directory, used as GOMODCACHE.
+	Dir string
+
+	// MaxSize is the size budget in bytes.
+	MaxSize int64
+
+	mu     sync.Mutex
+	builds map[*cacheBuild]struct{}
+}
+
+// cacheBuild is a build using the cache.
+type cacheBuild struct {
+	// key of the module version built.
+	key string
+
+	// started is the start time of the build.
+	started time.Time
+}
+
+// cacheEntry is a module version in the cache.
+type cacheEntry struct {
+	// key is the escaped module path and version, such as "example.com/!foo@v1.0.0".
+	key string
+
+	// mod is the path of the .mod file, which is touched when used.
+	mod string
+
+	// paths removed on eviction.
+	paths []string
+
+	// size in bytes.
+	size int64
+
+	// used is the last use time.
+	used time.Time
+}
+
+// Prune evicts the least recently used module versions until the cache
+// is within its size budget, returning the number of module versions
+// removed and the number of bytes freed. Module versions pinned by the
+// builds running are never evicted, so that pruning never waits for
+// builds, and builds never wait for pruning.
+func (c *Cache) Prune() (removed int, freed int64, err error) {
+	entries, err := c.entries()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var size int64
+	for _, e := range entries {
+		size += e.size
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].used.Before(entries[j].used)
+	})
+
+	for _, e := range entries {
+		if size <= c.MaxSize {
+			break
+		}
+
+		ok, err := c.evict(e)
+		if err != nil {
+			return removed, freed, err
+		}
+
+		if !ok {
+			continue
+		}
+
+		size -= e.size
+		freed += e.size
+		removed++
+	}
+
+	return
+}
+
+// evict removes the module version unless it is pinned, returning true if removed.
+func (c *Cache) evict(e *cacheEntry) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.pinned(e) {
+		return false, nil
+	}
+
+	for _, path := range e.paths {
+		err := os.RemoveAll(path)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+// pinned returns true if the module version may be used by a running build, that
+// is, it is the module built, or it was used since the oldest build started, c.mu
+// must be held. The .mod file is checked again, as builds touch the module
+// versions they use before compiling.
+func (c *Cache) pinned(e *cacheEntry) bool {
+	if len(c.builds) == 0 {
+		return false
+	}
+
+	var oldest time.Time
+	for b := range c.builds {
+		if b.key == e.key {
+			return true
+		}
+
+		if oldest.IsZero() || b.started.Before(oldest) {
+			oldest = b.started
+		}
+	}
+
+	used := e.used
+	if info, err := os.Stat(e.mod); err == nil && info.ModTime().After(used) {
+		used = info.ModTime()
+	}
+
+	return !used.Before(oldest)
+}
+
+// Size returns the size of the module versions in the cache.
+func (c *Cache) Size() (int64, error) {
+	entries, err := c.entries()
+	if err != nil {
+		return 0, err
+	}
+
+	var size int64
+	for _, e := range entries {
+		size += e.size
+	}
+
+	return size, nil
+}
+
+// acquire marks the cache as in use by a build of the given module dependency,
+// such as "example.com/tool@v1.0.0", pinning the module versions it uses.
+func (c *Cache) acquire(dep string) *cacheBuild {
+	i := strings.LastIndex(dep, "@")
+	b := &cacheBuild{
+		key:     filepath.FromSlash(escapePath(dep[:i])) + "@" + escapePath(dep[i+1:]),
+		started: time.Now(),
+	}
+
+	c.mu.Lock()
+	if c.builds == nil {
+		c.builds = make(map[*cacheBuild]struct{})
+	}
+	c.builds[b] = struct{}{}
+	c.mu.Unlock()
+
+	return b
+}
+
+// release marks the cache as no longer in use by the build.
+func (c *Cache) release(b *cacheBuild) {
+	c.mu.Lock()
+	delete(c.builds, b)
+	c.mu.Unlock()
+}
+
+// touch marks the module versions listed in the given go.sum file as recently used.
+func (c *Cache) touch(gosum string) error {
+	f, err := os.Open(gosum)
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	now := time.Now()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) < 2 {
+			continue
+		}
+
+		mod := escapePath(fields[0])
+		version := escapePath(strings.TrimSuffix(fields[1], "/go.mod"))
+		dir := filepath.Join(c.Dir, "cache", "download", filepath.FromSlash(mod), "@v")
+
+		err := os.Chtimes(filepath.Join(dir, version+".mod"), now, now)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return s.Err()
+}
+
+// entries returns the module versions in the cache. Each module version
+// is made up of its downloads and its extracted source directory, and
+// was last used when its .mod file was last modified.
+func (c *Cache) entries() (entries []*cacheEntry, err error) {
+	root := filepath.Join(c.Dir, "cache", "download")
+	versions := make(map[string]*cacheEntry)
+
+	err = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+
+		// ignore the checksum database
+		if info.IsDir() && rel == "sumdb" {
+			return filepath.SkipDir
+		}
+
+		// only files within a module's @v directory
+		dir, file := filepath.Split(rel)
+		if info.IsDir() || filepath.Base(dir) != "@v" || file == "list" {
+			return nil
+		}
+
+		mod := filepath.Dir(filepath.Clean(dir))
+		version := strings.TrimSuffix(file, filepath.Ext(file))
+		key := mod + "@" + version
+
+		e, ok := versions[key]
+		if !ok {
+			src := filepath.Join(c.Dir, key)
+			size, err := dirSize(src)
+			if err != nil {
+				return err
+			}
+
+			e = &cacheEntry{
+				key:   key,
+				mod:   filepath.Join(root, dir, version+".mod"),
+				paths: []string{src},
+				size:  size,
+			}
+			versions[key] = e
+			entries = append(entries, e)
+		}
+
+		e.paths = append(e.paths, path)
+		e.size += info.Size()
+
+		if info.ModTime().After(e.used) {
+			e.used = info.ModTime()
+		}
+
+		return nil
+	})
+
+	return
+}
+
+// dirSize returns the size of the files within dir, or zero if it does not exist.
+func dirSize(dir string) (size int64, err error) {
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+
+		return nil
+	})
+
+	return
+}
+
+// escapePath returns the module cache encoding of a module path or version,
+// where upper-case letters are replaced by "!" and the lower-case letter.
+func escapePath(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if unicode.IsUpper(r) {
+			b.WriteByte('!')
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,158 @@
+package build
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/tj/assert"
+
+	"github.com/tj/gobinaries"
+)
+
+// addCacheEntry adds a fake module version of the given size to the cache, last used at t.
+func addCacheEntry(tb testing.TB, c *Cache, mod, version string, size int, used time.Time) {
+	dir := filepath.Join(c.Dir, "cache", "download", filepath.FromSlash(escapePath(mod)), "@v")
+	src := filepath.Join(c.Dir, filepath.FromSlash(escapePath(mod))+"@"+version)
+
+	assert.NoError(tb, os.MkdirAll(dir, 0755))
+	assert.NoError(tb, os.MkdirAll(src, 0755))
+	assert.NoError(tb, ioutil.WriteFile(filepath.Join(dir, version+".mod"), nil, 0644))
+	assert.NoError(tb, ioutil.WriteFile(filepath.Join(dir, version+".zip"), make([]byte, size), 0644))
+	assert.NoError(tb, ioutil.WriteFile(filepath.Join(src, "main.go"), make([]byte, size), 0644))
+	assert.NoError(tb, os.Chtimes(filepath.Join(dir, version+".mod"), used, used))
+	assert.NoError(tb, os.Chtimes(filepath.Join(dir, version+".zip"), used, used))
+}
+
+// exists returns true if the path exists.
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// Test pruning the module cache.
+func TestCache_Prune(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gobinaries-cache")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := &Cache{
+		Dir:     dir,
+		MaxSize: 500,
+	}
+
+	now := time.Now()
+	addCacheEntry(t, c, "example.com/old", "v1.0.0", 100, now.Add(-3*time.Hour))
+	addCacheEntry(t, c, "example.com/old", "v1.1.0", 100, now.Add(-2*time.Hour))
+	addCacheEntry(t, c, "example.com/new", "v1.0.0", 100, now.Add(-time.Hour))
+
+	size, err := c.Size()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(600), size)
+
+	removed, freed, err := c.Prune()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, removed)
+	assert.Equal(t, int64(200), freed)
+
+	assert.False(t, exists(filepath.Join(dir, "example.com", "old@v1.0.0")))
+	assert.False(t, exists(filepath.Join(dir, "cache", "download", "example.com", "old", "@v", "v1.0.0.zip")))
+	assert.True(t, exists(filepath.Join(dir, "example.com", "old@v1.1.0")))
+	assert.True(t, exists(filepath.Join(dir, "example.com", "new@v1.0.0")))
+
+	t.Run("within budget", func(t *testing.T) {
+		removed, _, err := c.Prune()
+		assert.NoError(t, err)
+		assert.Equal(t, 0, removed)
+	})
+}
+
+// Test that pruning skips the module versions pinned by running builds.
+func TestCache_Prune_building(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gobinaries-cache")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := &Cache{
+		Dir:     dir,
+		MaxSize: 0,
+	}
+
+	now := time.Now()
+	addCacheEntry(t, c, "example.com/Built", "v1.0.0", 100, now.Add(-3*time.Hour))
+	addCacheEntry(t, c, "example.com/unused", "v1.0.0", 100, now.Add(-2*time.Hour))
+	addCacheEntry(t, c, "example.com/dep", "v1.0.0", 100, now.Add(-time.Hour))
+
+	b := c.acquire("example.com/Built@v1.0.0")
+
+	// touched by the build before compiling
+	used := time.Now().Add(time.Second)
+	mod := filepath.Join(dir, "cache", "download", "example.com", "dep", "@v", "v1.0.0.mod")
+	assert.NoError(t, os.Chtimes(mod, used, used))
+
+	removed, _, err := c.Prune()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, removed)
+	assert.True(t, exists(filepath.Join(dir, "example.com", "!built@v1.0.0")))
+	assert.True(t, exists(filepath.Join(dir, "example.com", "dep@v1.0.0")))
+	assert.False(t, exists(filepath.Join(dir, "example.com", "unused@v1.0.0")))
+
+	c.release(b)
+	removed, _, err = c.Prune()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, removed)
+	assert.False(t, exists(filepath.Join(dir, "example.com", "!built@v1.0.0")))
+	assert.False(t, exists(filepath.Join(dir, "example.com", "dep@v1.0.0")))
+}
+
+// Test that builds mark the module versions used.
+func TestCache_touch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gobinaries-cache")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := &Cache{
+		Dir:     dir,
+		MaxSize: 0,
+	}
+
+	b := Builder{
+		Cache: c,
+	}
+
+	addFakeModule(t, "example.com/Cached", "v1.0.0", "cached")
+
+	var buf bytes.Buffer
+	err = b.Write(&buf, gobinaries.Binary{
+		Path:    "example.com/Cached",
+		Module:  "example.com/Cached",
+		Version: "v1.0.0",
+		OS:      runtime.GOOS,
+		Arch:    runtime.GOARCH,
+	})
+	assert.NoError(t, err)
+
+	mod := filepath.Join(dir, "cache", "download", "example.com", "!cached", "@v", "v1.0.0.mod")
+	info, err := os.Stat(mod)
+	assert.NoError(t, err)
+	assert.True(t, time.Since(info.ModTime()) < time.Minute)
+
+	size, err := c.Size()
+	assert.NoError(t, err)
+	assert.True(t, size > 0)
+
+	removed, _, err := c.Prune()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, removed)
+	assert.False(t, exists(filepath.Join(dir, "example.com", "!cached@v1.0.0")))
+}
+
+// Test module cache path escaping.
+func TestEscapePath(t *testing.T) {
+	assert.Equal(t, "github.com/!azure/azure-sdk", escapePath("github.com/Azure/azure-sdk"))
+	assert.Equal(t, "v1.0.0-!r!c1", escapePath("v1.0.0-RC1"))
+}
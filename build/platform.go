@@ -0,0 +1,65 @@
+package build
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/tj/gobinaries"
+)
+
+// CheckPlatform returns gobinaries.ErrUnsupportedPlatform when the os and arch
+// are not listed by `go tool dist list`, where arch may be an ARM variant
+// such as "armv7".
+func (b *Builder) CheckPlatform(os, arch string) error {
+	platforms, err := b.platforms()
+	if err != nil {
+		return fmt.Errorf("listing platforms: %w", err)
+	}
+
+	goarch, _ := splitArch(arch)
+	if !platforms[os+"/"+goarch] {
+		return fmt.Errorf("%w: %s/%s", gobinaries.ErrUnsupportedPlatform, os, arch)
+	}
+
+	return nil
+}
+
+// platforms returns the os/arch pairs supported by the Go toolchain,
+// listed once and cached.
+func (b *Builder) platforms() (map[string]bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.supported != nil {
+		return b.supported, nil
+	}
+
+	var w strings.Builder
+	cmd := exec.Command("go", "tool", "dist", "list")
+	cmd.Env = b.environ()
+	cmd.Stdout = &w
+	err := command(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	b.supported = make(map[string]bool)
+	for _, p := range strings.Fields(w.String()) {
+		b.supported[p] = true
+	}
+
+	return b.supported, nil
+}
+
+// splitArch returns the GOARCH and GOARM of an architecture reported by the
+// install script, such as "arm" and "7" for "armv7". GOARM is empty for other
+// architectures.
+func splitArch(arch string) (goarch, goarm string) {
+	switch arch {
+	case "armv5", "armv6", "armv7":
+		return "arm", strings.TrimPrefix(arch, "armv")
+	default:
+		return arch, ""
+	}
+}
@@ -0,0 +1,66 @@
+package build
+
+import (
+	"errors"
+	"runtime"
+	"testing"
+
+	"github.com/tj/assert"
+
+	"github.com/tj/gobinaries"
+)
+
+// Test checking platforms against the Go toolchain.
+func TestBuilder_CheckPlatform(t *testing.T) {
+	var b Builder
+
+	cases := []struct {
+		os        string
+		arch      string
+		supported bool
+	}{
+		{runtime.GOOS, runtime.GOARCH, true},
+		{"linux", "amd64", true},
+		{"linux", "armv6", true},
+		{"linux", "armv7", true},
+		{"windows", "386", true},
+		{"darwin", "armv7", false},
+		{"linux", "armv8", false},
+		{"plan10", "amd64", false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.os+"/"+c.arch, func(t *testing.T) {
+			err := b.CheckPlatform(c.os, c.arch)
+			if c.supported {
+				assert.NoError(t, err)
+			} else {
+				assert.True(t, errors.Is(err, gobinaries.ErrUnsupportedPlatform))
+			}
+		})
+	}
+}
+
+// Test translating ARM variants.
+func TestSplitArch(t *testing.T) {
+	cases := []struct {
+		arch   string
+		goarch string
+		goarm  string
+	}{
+		{"amd64", "amd64", ""},
+		{"arm", "arm", ""},
+		{"arm64", "arm64", ""},
+		{"armv5", "arm", "5"},
+		{"armv6", "arm", "6"},
+		{"armv7", "arm", "7"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.arch, func(t *testing.T) {
+			goarch, goarm := splitArch(c.arch)
+			assert.Equal(t, c.goarch, goarch)
+			assert.Equal(t, c.goarm, goarm)
+		})
+	}
+}
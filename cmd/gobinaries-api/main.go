@@ -2,8 +2,17 @@ package main
 
 import (
 	"context"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
 	"net/http"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
 	googlestorage "cloud.google.com/go/storage"
 	"github.com/apex/httplog"
@@ -11,10 +20,15 @@ import (
 	"github.com/apex/log/handlers/apexlogs"
 	"github.com/apex/log/handlers/logfmt"
 	"github.com/apex/log/handlers/multi"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/google/go-github/v28/github"
 	"github.com/tj/go/env"
 	"golang.org/x/oauth2"
 
+	"github.com/tj/gobinaries"
+	"github.com/tj/gobinaries/build"
 	"github.com/tj/gobinaries/resolver"
 	"github.com/tj/gobinaries/server"
 	"github.com/tj/gobinaries/storage"
@@ -35,32 +49,73 @@ func main() {
 	// context
 	ctx := context.Background()
 
-	// github client
-	gh := oauth2.StaticTokenSource(
-		&oauth2.Token{
-			AccessToken: env.Get("GITHUB_TOKEN"),
-		},
-	)
+	// storage
+	prefix := env.GetDefault("STORAGE_PREFIX", "production")
+	store, err := newStorage(ctx, prefix)
+	if err != nil {
+		log.Fatalf("error creating storage: %s", err)
+	}
+
+	// storage cache
+	if size := intEnv("STORAGE_CACHE_SIZE_MB"); size > 0 {
+		c := &storage.Cache{
+			Storage: store,
+			MaxSize: int64(size) << 20,
+			Dir:     os.Getenv("STORAGE_CACHE_DIR"),
+		}
+		go reportCacheStats(c, 10*time.Minute)
+		store = c
+	}
+
+	// private storage, under a separate prefix
+	var privateStore gobinaries.Storage
+	private := os.Getenv("PRIVATE")
+	if private != "" {
+		privateStore, err = newStorage(ctx, env.GetDefault("STORAGE_PRIVATE_PREFIX", prefix+"-private"))
+		if err != nil {
+			log.Fatalf("error creating private storage: %s", err)
+		}
+	}
+
+	// module cache
+	cache := &build.Cache{
+		Dir:     env.GetDefault("BUILD_CACHE_DIR", filepath.Join(os.TempDir(), "gobinaries", "modcache")),
+		MaxSize: int64(intEnvDefault("BUILD_CACHE_SIZE_MB", 5<<10)) << 20,
+	}
+
+	go janitor(cache, 10*time.Minute)
 
-	// storage client
-	gs, err := googlestorage.NewClient(ctx)
+	// signing key
+	key, err := newSigningKey()
 	if err != nil {
-		log.Fatalf("error creating storage client: %s", err)
+		log.Fatalf("error parsing signing key: %s", err)
 	}
 
 	// server
 	addr := ":" + env.GetDefault("PORT", "3000")
 	s := &server.Server{
-		Static: "static",
-		URL:    env.GetDefault("URL", "http://127.0.0.1"+addr),
-		Resolver: &resolver.GitHub{
-			Client: github.NewClient(oauth2.NewClient(ctx, gh)),
-		},
-		Storage: &storage.Google{
-			Client: gs,
-			Bucket: "gobinaries",
-			Prefix: "production",
+		Static:   "static",
+		URL:      env.GetDefault("URL", "http://127.0.0.1"+addr),
+		Resolver: newResolver(ctx),
+		Storage:  store,
+		Builder: &build.Builder{
+			Cache:               cache,
+			Toolchain:           os.Getenv("BUILD_TOOLCHAIN"),
+			Proxy:               os.Getenv("BUILD_GOPROXY"),
+			SumDB:               os.Getenv("BUILD_GOSUMDB"),
+			Private:             env.GetDefault("BUILD_GOPRIVATE", private),
+			NoProxy:             os.Getenv("BUILD_GONOPROXY"),
+			NoSumDB:             os.Getenv("BUILD_GONOSUMDB"),
+			Netrc:               os.Getenv("BUILD_NETRC"),
+			GitCredentialHelper: os.Getenv("BUILD_GIT_CREDENTIAL_HELPER"),
 		},
+		Private:          private,
+		Tokens:           listEnv("API_TOKENS"),
+		PrivateStorage:   privateStore,
+		SigningKey:       key,
+		Concurrency:      intEnv("BUILD_CONCURRENCY"),
+		QueueSize:        intEnv("BUILD_QUEUE_SIZE"),
+		StoreConcurrency: intEnv("STORE_CONCURRENCY"),
 	}
 
 	// add request level logging
@@ -74,6 +129,211 @@ func main() {
 	}
 }
 
+// newStorage returns the storage selected by the STORAGE environment variable, with the given prefix.
+func newStorage(ctx context.Context, prefix string) (gobinaries.Storage, error) {
+	switch kind := env.GetDefault("STORAGE", "google"); kind {
+	case "google":
+		client, err := googlestorage.NewClient(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		return &storage.Google{
+			Client: client,
+			Bucket: env.GetDefault("STORAGE_BUCKET", "gobinaries"),
+			Prefix: prefix,
+		}, nil
+	case "filesystem":
+		return &storage.Filesystem{
+			Dir:    env.Get("STORAGE_DIR"),
+			Prefix: prefix,
+		}, nil
+	case "s3":
+		client, err := newS3Client(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		return &storage.S3{
+			Client:            client,
+			Bucket:            env.GetDefault("STORAGE_BUCKET", "gobinaries"),
+			Prefix:            prefix,
+			ForbiddenNotFound: os.Getenv("S3_FORBIDDEN_NOT_FOUND") != "",
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported storage %q", kind)
+	}
+}
+
+// newS3Client returns an S3 client using the default AWS credential chain, such as
+// AWS_ACCESS_KEY_ID, the web identity of IAM roles for service accounts, or the
+// instance's role, or anonymous requests when S3_ANONYMOUS is set. S3_ENDPOINT
+// and S3_PATH_STYLE configure S3-compatible stores such as MinIO.
+func newS3Client(ctx context.Context) (*s3.Client, error) {
+	var opts []func(*config.LoadOptions) error
+	if region := os.Getenv("S3_REGION"); region != "" {
+		opts = append(opts, config.WithRegion(region))
+	}
+
+	cfg, err := config.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("loading aws config: %w", err)
+	}
+
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+
+	if os.Getenv("S3_ANONYMOUS") != "" {
+		cfg.Credentials = aws.AnonymousCredentials{}
+	}
+
+	return s3.NewFromConfig(cfg, func(o *s3.Options) {
+		if endpoint := os.Getenv("S3_ENDPOINT"); endpoint != "" {
+			o.EndpointResolver = s3.EndpointResolverFromURL(endpoint)
+		}
+		o.UsePathStyle = os.Getenv("S3_PATH_STYLE") != ""
+	}), nil
+}
+
+// newSigningKey returns the PEM encoded PKCS #8 ed25519 key of the SIGNING_KEY
+// environment variable, such as generated by `openssl genpkey -algorithm ed25519`,
+// or nil when unset.
+func newSigningKey() (ed25519.PrivateKey, error) {
+	s := os.Getenv("SIGNING_KEY")
+	if s == "" {
+		return nil, nil
+	}
+
+	block, _ := pem.Decode([]byte(s))
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	k, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("unsupported key type %T", key)
+	}
+
+	return k, nil
+}
+
+// newResolver returns the resolver selected by the RESOLVER environment variable.
+func newResolver(ctx context.Context) gobinaries.Resolver {
+	switch kind := env.GetDefault("RESOLVER", "registry"); kind {
+	case "registry":
+		return &resolver.Registry{
+			Hosts: map[string]gobinaries.Resolver{
+				"github.com": newGitHubResolver(ctx),
+			},
+			Default: newProxyResolver(),
+		}
+	case "github":
+		return newGitHubResolver(ctx)
+	case "proxy":
+		return newProxyResolver()
+	default:
+		log.Fatalf("unsupported resolver %q", kind)
+		return nil
+	}
+}
+
+// newGitHubResolver returns a cached GitHub resolver.
+func newGitHubResolver(ctx context.Context) gobinaries.Resolver {
+	gh := oauth2.StaticTokenSource(
+		&oauth2.Token{
+			AccessToken: env.Get("GITHUB_TOKEN"),
+		},
+	)
+
+	return &resolver.Cache{
+		Source: &resolver.GitHub{
+			Client: github.NewClient(oauth2.NewClient(ctx, gh)),
+		},
+	}
+}
+
+// newProxyResolver returns a cached module proxy resolver.
+func newProxyResolver() gobinaries.Resolver {
+	return &resolver.CachingResolver{
+		Resolver: &resolver.Proxy{
+			URL: os.Getenv("RESOLVER_PROXY_URL"),
+		},
+	}
+}
+
+// intEnv returns an integer environment variable, or zero when unset.
+func intEnv(name string) int {
+	return intEnvDefault(name, 0)
+}
+
+// intEnvDefault returns an integer environment variable, or value when unset.
+func intEnvDefault(name string, value int) int {
+	s := os.Getenv(name)
+	if s == "" {
+		return value
+	}
+
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		log.Fatalf("error parsing %s: %s", name, err)
+	}
+
+	return n
+}
+
+// listEnv returns a comma-separated list environment variable, or nil when unset.
+func listEnv(name string) (list []string) {
+	for _, v := range strings.Split(os.Getenv(name), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return
+}
+
+// janitor prunes the module cache periodically.
+func janitor(c *build.Cache, interval time.Duration) {
+	for range time.Tick(interval) {
+		start := time.Now()
+		removed, freed, err := c.Prune()
+		if err != nil {
+			log.WithError(err).Error("error pruning module cache")
+			continue
+		}
+
+		duration := int(time.Since(start) / time.Millisecond)
+		if removed == 0 {
+			log.WithField("duration", duration).Info("skipped pruning module cache, within budget or in use")
+			continue
+		}
+
+		log.WithFields(log.Fields{
+			"removed":  removed,
+			"freed":    freed,
+			"duration": duration,
+		}).Info("pruned module cache")
+	}
+}
+
+// reportCacheStats logs storage cache statistics periodically.
+func reportCacheStats(c *storage.Cache, interval time.Duration) {
+	for range time.Tick(interval) {
+		stats := c.Stats()
+		log.WithFields(log.Fields{
+			"hits":    stats.Hits,
+			"misses":  stats.Misses,
+			"objects": stats.Objects,
+			"size":    stats.Size,
+		}).Info("storage cache stats")
+	}
+}
+
 // Flusher interface.
 type Flusher interface {
 	Flush() error
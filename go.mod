@@ -6,10 +6,15 @@ require (
 	cloud.google.com/go/storage v1.6.0
 	github.com/apex/httplog v1.0.0
 	github.com/apex/log v1.4.0
+	github.com/aws/aws-sdk-go-v2 v1.17.8
+	github.com/aws/aws-sdk-go-v2/config v1.18.21
+	github.com/aws/aws-sdk-go-v2/credentials v1.13.20
+	github.com/aws/aws-sdk-go-v2/service/s3 v1.31.3
 	github.com/google/go-github/v28 v28.1.1
 	github.com/tj/assert v0.0.0-20190920132354-ee03d75cd160
 	github.com/tj/go v1.8.6
 	github.com/tj/go-semver v1.0.0
+	golang.org/x/mod v0.4.2
 	golang.org/x/oauth2 v0.0.0-20200107190931-bf48bf16ab8d
-	gopkg.in/yaml.v2 v2.2.7 // indirect
+	google.golang.org/api v0.18.0
 )
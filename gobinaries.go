@@ -5,42 +5,170 @@ import (
 	"context"
 	"errors"
 	"io"
+	"time"
 )
 
 // ErrObjectNotFound is returned by Storage.Get() when no object is found for the specified key.
 var ErrObjectNotFound = errors.New("no cloud storage object")
 
+// ErrStorageUnavailable is returned by Storage when a transient failure occurs, such as a timeout.
+var ErrStorageUnavailable = errors.New("storage unavailable")
+
+// ErrStorageForbidden is returned by Storage when permission to access an object is denied.
+var ErrStorageForbidden = errors.New("storage permission denied")
+
 // ErrNoVersionMatch is returned by Resolver.Resolve() when no tag matches the requested version.
 var ErrNoVersionMatch = errors.New("no matching version")
 
 // ErrNoVersions is returned by Resolver.Resolve() when no versions are defined.
 var ErrNoVersions = errors.New("no versions defined")
 
-// Resolver is the interface used to resolver package versions.
+// ErrUnsupportedHost is returned by Resolver.Resolve() when modules of the host cannot be resolved.
+var ErrUnsupportedHost = errors.New("unsupported host")
+
+// ErrUnsupportedPlatform is returned by PlatformChecker.CheckPlatform() when binaries cannot be built for the os and arch.
+var ErrUnsupportedPlatform = errors.New("unsupported platform")
+
+// Resolver is the interface used to resolve the version of a module
+// path such as "github.com/tj/staticgen".
 type Resolver interface {
-	Resolve(owner, repo, version string) (string, error)
+	Resolve(mod, version string) (string, error)
+}
+
+// ModuleResolver is an optional interface implemented by resolvers which find
+// the module of a package path such as "golang.org/x/tools/cmd/stringer".
+type ModuleResolver interface {
+	ResolveModule(pkg string) (string, error)
 }
 
-// Storage is the interface used for storing compiled Go binaries.
+// DeprecationResolver is an optional interface implemented by resolvers which
+// find the deprecation message of a module, from the "Deprecated:" comment of
+// its latest go.mod file. An empty string is returned when it is not deprecated.
+type DeprecationResolver interface {
+	ResolveDeprecation(mod string) (string, error)
+}
+
+// PackageResolver is an optional interface implemented by resolvers which find
+// the package at a resolved version, using the module path declared by its go.mod
+// file, such as "github.com/tj/triage/v2/cmd/triage" for "github.com/tj/triage/cmd/triage".
+type PackageResolver interface {
+	ResolvePackage(mod, pkg, version string) (Package, error)
+}
+
+// Storage is the interface used for storing compiled Go binaries, and the
+// sidecar files stored next to them, such as their "sha256" checksum.
 type Storage interface {
 	Create(context.Context, io.Reader, Binary) error
 	Get(context.Context, Binary) (io.ReadCloser, error)
+	CreateSidecar(ctx context.Context, r io.Reader, bin Binary, name string) error
+	GetSidecar(ctx context.Context, bin Binary, name string) (io.ReadCloser, error)
+}
+
+// Builder is the interface used for compiling Go binaries.
+type Builder interface {
+	Write(io.Writer, Binary) error
+}
+
+// BuildWriter is an optional interface implemented by builders which report
+// the details of each build, such as the Go toolchain used.
+type BuildWriter interface {
+	WriteBuild(io.Writer, Binary) (Build, error)
+}
+
+// PlatformChecker is an optional interface implemented by builders which check
+// that binaries can be built for an os and arch such as "linux" and "armv7".
+type PlatformChecker interface {
+	CheckPlatform(os, arch string) error
+}
+
+// Package represents a package at a resolved version.
+type Package struct {
+	// Path is the package path such as "github.com/tj/triage/v2/cmd/triage".
+	Path string
+
+	// Module path such as "github.com/tj/triage/v2".
+	Module string
+
+	// Version is the module version such as "v2.1.0" or "v2.0.0+incompatible".
+	Version string
+}
+
+// Build represents the details of a binary's build.
+type Build struct {
+	// Toolchain is the Go toolchain version such as "go1.21.5".
+	Toolchain string
+
+	// Flags are the `go build` flags such as "-trimpath".
+	Flags []string
+
+	// Ldflags are the linker flags such as "-X main.version=v1.0.0 -buildid=".
+	Ldflags string
+
+	// Env is the build environment such as "GOOS=linux", including the module
+	// proxy, checksum database, flags and toolchain selection of the build.
+	Env []string
+
+	// Reproducible is true when the toolchain is pinned, so that the
+	// build produces an identical binary regardless of the host.
+	Reproducible bool
+
+	// Sum is the hash of the module's files from go.sum, such as "h1:...".
+	Sum string
+
+	// GoSum is the lines of the go.sum file, with the hashes of every module used.
+	GoSum []string
+
+	// Started is the time the build started.
+	Started time.Time
+
+	// Finished is the time the build finished.
+	Finished time.Time
+}
+
+// Metadata represents the metadata of a binary, stored next to it as the "json" sidecar.
+type Metadata struct {
+	// Binary is the binary built.
+	Binary Binary `json:"binary"`
+
+	// Toolchain is the Go toolchain version such as "go1.21.5".
+	Toolchain string `json:"toolchain,omitempty"`
+
+	// Ldflags are the linker flags of the build.
+	Ldflags string `json:"ldflags,omitempty"`
+
+	// ModuleSum is the hash of the module's files from go.sum, such as "h1:...".
+	ModuleSum string `json:"moduleSum,omitempty"`
+
+	// GoSum is the lines of the go.sum file, with the hashes of every module used.
+	GoSum []string `json:"goSum,omitempty"`
+
+	// Size is the size of the binary in bytes.
+	Size int64 `json:"size"`
+
+	// SHA256 is the hex encoded SHA-256 checksum of the binary.
+	SHA256 string `json:"sha256"`
+
+	// Duration is the duration of the build in milliseconds.
+	Duration int `json:"durationMs"`
+
+	// Built is the time the build finished.
+	Built time.Time `json:"built"`
 }
 
 // Binary represents the details of a package binary.
 type Binary struct {
 	// Path is the command path such as "github.com/tj/staticgen/cmd/staticgen".
-	Path string
+	Path string `json:"path"`
 
 	// Module path such as "github.com/tj/staticgen".
-	Module string
+	Module string `json:"module"`
 
 	// Version is the version of the package.
-	Version string
+	Version string `json:"version"`
 
 	// OS is the the target operating system.
-	OS string
+	OS string `json:"os"`
 
 	// Arch is the target architecture.
-	Arch string
+	Arch string `json:"arch"`
 }
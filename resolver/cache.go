@@ -0,0 +1,108 @@
+package resolver
+
+import (
+	"strings"
+	"time"
+
+	"github.com/tj/gobinaries"
+)
+
+// Cache is a resolver caching the tags and go.mod files of each repository
+// listed by Source. Stale entries are served while they are refreshed in the
+// background, and repositories without versions are cached briefly. Expired
+// entries are removed periodically, so that the cache does not grow without bound.
+type Cache struct {
+	// Source is used to fetch repository tags, commits and go.mod files.
+	Source Source
+
+	// TTL is the duration entries are fresh for, defaulting to 5 minutes.
+	TTL time.Duration
+
+	// StaleTTL is the duration stale entries may be served for while
+	// refreshing after the TTL has elapsed, defaulting to 24 hours.
+	StaleTTL time.Duration
+
+	// NegativeTTL is the duration ErrNoVersions is cached for, defaulting to 1 minute.
+	NegativeTTL time.Duration
+
+	cache staleCache
+}
+
+// Resolve implementation.
+func (c *Cache) Resolve(mod, version string) (string, error) {
+	owner, repo, dir, err := splitModule(mod)
+	if err != nil {
+		return "", err
+	}
+
+	return resolveSource(c, owner, repo, dir, version)
+}
+
+// ResolveDeprecation implementation.
+func (c *Cache) ResolveDeprecation(mod string) (string, error) {
+	owner, repo, dir, err := splitModule(mod)
+	if err != nil {
+		return "", err
+	}
+
+	return deprecationSource(c, owner, repo, dir)
+}
+
+// ResolvePackage implementation.
+func (c *Cache) ResolvePackage(mod, pkg, version string) (gobinaries.Package, error) {
+	owner, repo, dir, err := splitModule(mod)
+	if err != nil {
+		return gobinaries.Package{}, err
+	}
+
+	return resolvePackageSource(c, owner, repo, dir, mod, pkg, version)
+}
+
+// ResolveModule implementation.
+func (c *Cache) ResolveModule(pkg string) (string, error) {
+	owner, repo, _, err := splitModule(pkg)
+	if err != nil {
+		return "", err
+	}
+
+	return moduleSource(c, owner, repo, pkg)
+}
+
+// Commit returns the commit of a branch or commit SHA, which are not cached.
+func (c *Cache) Commit(owner, repo, ref string) (Commit, error) {
+	return c.Source.Commit(owner, repo, ref)
+}
+
+// Versions returns the tags of a repository, from the cache when possible.
+func (c *Cache) Versions(owner, repo string) ([]string, error) {
+	key := strings.ToLower("versions:" + owner + "/" + repo)
+	v, err := c.get(key, func() (interface{}, error) {
+		return c.Source.Versions(owner, repo)
+	})
+
+	tags, _ := v.([]string)
+	return tags, err
+}
+
+// GoMod returns the go.mod file in the directory dir of a repository
+// at the given tag or commit, from the cache when possible.
+func (c *Cache) GoMod(owner, repo, ref, dir string) ([]byte, error) {
+	key := strings.ToLower("gomod:"+owner+"/"+repo) + "@" + ref + ":" + dir
+	v, err := c.get(key, func() (interface{}, error) {
+		return c.Source.GoMod(owner, repo, ref, dir)
+	})
+
+	b, _ := v.([]byte)
+	return b, err
+}
+
+// Len returns the number of cached entries.
+func (c *Cache) Len() int {
+	return c.cache.len()
+}
+
+// get returns the value of an entry, from the cache when possible.
+func (c *Cache) get(key string, fetch func() (interface{}, error)) (interface{}, error) {
+	c.cache.init(c.TTL, c.StaleTTL, c.NegativeTTL)
+	return c.cache.get(key, fetch)
+}
@@ -0,0 +1,204 @@
+package resolver_test
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tj/assert"
+
+	"github.com/tj/gobinaries"
+	"github.com/tj/gobinaries/resolver"
+)
+
+// fakeSource is a source returning the given tags.
+type fakeSource struct {
+	mu    sync.Mutex
+	tags  []string
+	err   error
+	calls int
+}
+
+// Versions implementation.
+func (f *fakeSource) Versions(owner, repo string) ([]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls++
+	return f.tags, f.err
+}
+
+// Commit implementation.
+func (f *fakeSource) Commit(owner, repo, ref string) (resolver.Commit, error) {
+	return resolver.Commit{}, gobinaries.ErrNoVersionMatch
+}
+
+// GoMod implementation.
+func (f *fakeSource) GoMod(owner, repo, ref, dir string) ([]byte, error) {
+	return nil, nil
+}
+
+// set the tags and error returned.
+func (f *fakeSource) set(tags []string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.tags = tags
+	f.err = err
+}
+
+// count returns the number of calls.
+func (f *fakeSource) count() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls
+}
+
+// eventually waits for fn to return true.
+func eventually(t testing.TB, fn func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !fn() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// Test caching resolver.
+func TestCache_Resolve(t *testing.T) {
+	t.Run("fresh", func(t *testing.T) {
+		l := &fakeSource{tags: []string{"v1.1.0", "v1.0.0"}}
+		c := &resolver.Cache{Source: l}
+
+		for i := 0; i < 3; i++ {
+			v, err := c.Resolve("github.com/tj/d3-bar", "1.0.x")
+			assert.NoError(t, err)
+			assert.Equal(t, "v1.0.0", v)
+		}
+
+		assert.Equal(t, 1, l.count())
+	})
+
+	t.Run("stale while revalidate", func(t *testing.T) {
+		l := &fakeSource{tags: []string{"v1.0.0"}}
+		c := &resolver.Cache{
+			Source: l,
+			TTL:    10 * time.Millisecond,
+		}
+
+		v, err := c.Resolve("github.com/tj/d3-bar", "latest")
+		assert.NoError(t, err)
+		assert.Equal(t, "v1.0.0", v)
+
+		l.set([]string{"v1.1.0", "v1.0.0"}, nil)
+		time.Sleep(20 * time.Millisecond)
+
+		// stale
+		v, err = c.Resolve("github.com/tj/d3-bar", "latest")
+		assert.NoError(t, err)
+		assert.Equal(t, "v1.0.0", v)
+
+		// refreshed
+		eventually(t, func() bool {
+			v, err := c.Resolve("github.com/tj/d3-bar", "latest")
+			return err == nil && v == "v1.1.0"
+		})
+		assert.Equal(t, 2, l.count())
+	})
+
+	t.Run("stale on refresh error", func(t *testing.T) {
+		l := &fakeSource{tags: []string{"v1.0.0"}}
+		c := &resolver.Cache{
+			Source: l,
+			TTL:    10 * time.Millisecond,
+		}
+
+		_, err := c.Resolve("github.com/tj/d3-bar", "latest")
+		assert.NoError(t, err)
+
+		l.set(nil, errors.New("rate limited"))
+		time.Sleep(20 * time.Millisecond)
+
+		for i := 0; i < 3; i++ {
+			v, err := c.Resolve("github.com/tj/d3-bar", "latest")
+			assert.NoError(t, err)
+			assert.Equal(t, "v1.0.0", v)
+		}
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		l := &fakeSource{tags: []string{"v1.0.0"}}
+		c := &resolver.Cache{
+			Source:   l,
+			TTL:      5 * time.Millisecond,
+			StaleTTL: 5 * time.Millisecond,
+		}
+
+		_, err := c.Resolve("github.com/tj/d3-bar", "latest")
+		assert.NoError(t, err)
+
+		l.set([]string{"v1.1.0"}, nil)
+		time.Sleep(20 * time.Millisecond)
+
+		v, err := c.Resolve("github.com/tj/d3-bar", "latest")
+		assert.NoError(t, err)
+		assert.Equal(t, "v1.1.0", v)
+	})
+
+	t.Run("negative", func(t *testing.T) {
+		l := &fakeSource{err: gobinaries.ErrNoVersions}
+		c := &resolver.Cache{
+			Source:      l,
+			NegativeTTL: 20 * time.Millisecond,
+		}
+
+		for i := 0; i < 3; i++ {
+			_, err := c.Resolve("github.com/tj/d3-bar", "latest")
+			assert.Equal(t, gobinaries.ErrNoVersions, err)
+		}
+		assert.Equal(t, 1, l.count())
+
+		l.set([]string{"v1.0.0"}, nil)
+		time.Sleep(30 * time.Millisecond)
+
+		v, err := c.Resolve("github.com/tj/d3-bar", "latest")
+		assert.NoError(t, err)
+		assert.Equal(t, "v1.0.0", v)
+	})
+
+	t.Run("sweep", func(t *testing.T) {
+		l := &fakeSource{tags: []string{"v1.0.0"}}
+		c := &resolver.Cache{
+			Source:   l,
+			TTL:      5 * time.Millisecond,
+			StaleTTL: 5 * time.Millisecond,
+		}
+
+		_, err := c.Resolve("github.com/tj/repo", "latest")
+		assert.NoError(t, err)
+		n := c.Len()
+		assert.True(t, n > 0)
+
+		for _, repo := range []string{"a", "b", "c"} {
+			_, err := c.Resolve("github.com/tj/"+repo, "latest")
+			assert.NoError(t, err)
+		}
+		assert.Equal(t, 4*n, c.Len())
+
+		time.Sleep(20 * time.Millisecond)
+		_, err = c.Resolve("github.com/tj/d", "latest")
+		assert.NoError(t, err)
+		assert.Equal(t, n, c.Len())
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		l := &fakeSource{err: errors.New("boom")}
+		c := &resolver.Cache{Source: l}
+
+		for i := 0; i < 3; i++ {
+			_, err := c.Resolve("github.com/tj/d3-bar", "latest")
+			assert.EqualError(t, err, "boom")
+		}
+		assert.Equal(t, 3, l.count())
+	})
+}
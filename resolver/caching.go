@@ -0,0 +1,118 @@
+package resolver
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/tj/gobinaries"
+)
+
+// CachingResolver is a resolver caching the results of another resolver, such
+// as a Proxy, where stale results are served while they are refreshed in the
+// background. Only "latest" and version ranges such as "1.x" are cached when
+// resolving, as branches and commits move, and exact versions resolve to
+// themselves. Optional interfaces of the resolver which are not implemented
+// behave as if the resolver was used directly.
+type CachingResolver struct {
+	// Resolver is the resolver whose results are cached.
+	Resolver gobinaries.Resolver
+
+	// TTL is the duration results are fresh for, defaulting to 5 minutes.
+	TTL time.Duration
+
+	// StaleTTL is the duration stale results may be served for while
+	// refreshing after the TTL has elapsed, defaulting to 24 hours.
+	StaleTTL time.Duration
+
+	// NegativeTTL is the duration ErrNoVersions is cached for, defaulting to 1 minute.
+	NegativeTTL time.Duration
+
+	cache staleCache
+}
+
+// Resolve implementation.
+func (c *CachingResolver) Resolve(mod, version string) (string, error) {
+	if !cacheable(version) {
+		return c.Resolver.Resolve(mod, version)
+	}
+
+	v, err := c.get("resolve:"+mod+"@"+version, func() (interface{}, error) {
+		return c.Resolver.Resolve(mod, version)
+	})
+
+	s, _ := v.(string)
+	return s, err
+}
+
+// ResolveDeprecation implementation.
+func (c *CachingResolver) ResolveDeprecation(mod string) (string, error) {
+	d, ok := c.Resolver.(gobinaries.DeprecationResolver)
+	if !ok {
+		return "", nil
+	}
+
+	v, err := c.get("deprecation:"+mod, func() (interface{}, error) {
+		return d.ResolveDeprecation(mod)
+	})
+
+	s, _ := v.(string)
+	return s, err
+}
+
+// ResolvePackage implementation.
+func (c *CachingResolver) ResolvePackage(mod, pkg, version string) (gobinaries.Package, error) {
+	p, ok := c.Resolver.(gobinaries.PackageResolver)
+	if !ok {
+		return gobinaries.Package{}, fmt.Errorf("resolving packages of %s is not supported", host(mod))
+	}
+
+	v, err := c.get("package:"+mod+":"+pkg+"@"+version, func() (interface{}, error) {
+		return p.ResolvePackage(mod, pkg, version)
+	})
+
+	resolved, _ := v.(gobinaries.Package)
+	return resolved, err
+}
+
+// ResolveModule implementation. The module of resolvers which do not implement
+// gobinaries.ModuleResolver is the first three elements of the package path.
+func (c *CachingResolver) ResolveModule(pkg string) (string, error) {
+	m, ok := c.Resolver.(gobinaries.ModuleResolver)
+	if !ok {
+		parts := strings.Split(pkg, "/")
+		if len(parts) < 3 {
+			return "", fmt.Errorf("invalid package path %q", pkg)
+		}
+		return strings.Join(parts[:3], "/"), nil
+	}
+
+	v, err := c.get("module:"+pkg, func() (interface{}, error) {
+		return m.ResolveModule(pkg)
+	})
+
+	s, _ := v.(string)
+	return s, err
+}
+
+// Len returns the number of cached results.
+func (c *CachingResolver) Len() int {
+	return c.cache.len()
+}
+
+// get returns the result of the key, from the cache when possible.
+func (c *CachingResolver) get(key string, fetch func() (interface{}, error)) (interface{}, error) {
+	c.cache.init(c.TTL, c.StaleTTL, c.NegativeTTL)
+	return c.cache.get(key, fetch)
+}
+
+// cacheable returns true if the requested version is "latest" or a range,
+// not a branch, commit or exact version.
+func cacheable(version string) bool {
+	if !isRange(version) {
+		return false
+	}
+
+	_, exact := parseVersion(version)
+	return !exact
+}
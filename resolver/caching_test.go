@@ -0,0 +1,162 @@
+package resolver_test
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tj/assert"
+
+	"github.com/tj/gobinaries"
+	"github.com/tj/gobinaries/resolver"
+)
+
+// countingResolver is a resolver counting its calls.
+type countingResolver struct {
+	mu      sync.Mutex
+	version string
+	err     error
+	calls   int
+}
+
+// Resolve implementation.
+func (c *countingResolver) Resolve(mod, version string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.calls++
+	return c.version, c.err
+}
+
+// count returns the number of calls.
+func (c *countingResolver) count() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.calls
+}
+
+// packageResolver is a resolver implementing every optional interface.
+type packageResolver struct {
+	countingResolver
+}
+
+// ResolveDeprecation implementation.
+func (p *packageResolver) ResolveDeprecation(mod string) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.calls++
+	return "use something else", nil
+}
+
+// ResolvePackage implementation.
+func (p *packageResolver) ResolvePackage(mod, pkg, version string) (gobinaries.Package, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.calls++
+	return gobinaries.Package{Path: pkg, Module: mod, Version: version}, nil
+}
+
+// ResolveModule implementation.
+func (p *packageResolver) ResolveModule(pkg string) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.calls++
+	return "example.com/mod", nil
+}
+
+// Test caching the results of a resolver.
+func TestCachingResolver(t *testing.T) {
+	t.Run("resolve", func(t *testing.T) {
+		r := &countingResolver{version: "v1.0.0"}
+		c := &resolver.CachingResolver{Resolver: r}
+
+		for i := 0; i < 3; i++ {
+			v, err := c.Resolve("golang.org/x/tools", "latest")
+			assert.NoError(t, err)
+			assert.Equal(t, "v1.0.0", v)
+		}
+		assert.Equal(t, 1, r.count())
+
+		_, err := c.Resolve("golang.org/x/tools", "v0.1.x")
+		assert.NoError(t, err)
+		assert.Equal(t, 2, r.count())
+	})
+
+	t.Run("branches, commits and exact versions", func(t *testing.T) {
+		r := &countingResolver{version: "v1.0.0"}
+		c := &resolver.CachingResolver{Resolver: r}
+
+		for _, version := range []string{"master", "0123456", "v1.0.0", "1.0.0", "v1.1.0-rc.1"} {
+			for i := 0; i < 2; i++ {
+				_, err := c.Resolve("golang.org/x/tools", version)
+				assert.NoError(t, err)
+			}
+		}
+		assert.Equal(t, 10, r.count())
+		assert.Equal(t, 0, c.Len())
+	})
+
+	t.Run("optional interfaces", func(t *testing.T) {
+		r := &packageResolver{countingResolver{version: "v1.0.0"}}
+		c := &resolver.CachingResolver{Resolver: r}
+
+		for i := 0; i < 3; i++ {
+			d, err := c.ResolveDeprecation("example.com/mod")
+			assert.NoError(t, err)
+			assert.Equal(t, "use something else", d)
+
+			p, err := c.ResolvePackage("example.com/mod", "example.com/mod/cmd/tool", "v1.0.0")
+			assert.NoError(t, err)
+			assert.Equal(t, gobinaries.Package{Path: "example.com/mod/cmd/tool", Module: "example.com/mod", Version: "v1.0.0"}, p)
+
+			m, err := c.ResolveModule("example.com/mod/cmd/tool")
+			assert.NoError(t, err)
+			assert.Equal(t, "example.com/mod", m)
+		}
+		assert.Equal(t, 3, r.count())
+	})
+
+	t.Run("optional interfaces not implemented", func(t *testing.T) {
+		c := &resolver.CachingResolver{Resolver: &countingResolver{}}
+
+		d, err := c.ResolveDeprecation("example.com/mod")
+		assert.NoError(t, err)
+		assert.Equal(t, "", d)
+
+		_, err = c.ResolvePackage("example.com/mod", "example.com/mod/cmd/tool", "v1.0.0")
+		assert.Error(t, err)
+
+		m, err := c.ResolveModule("example.com/mod/cmd/tool")
+		assert.NoError(t, err)
+		assert.Equal(t, "example.com/mod/cmd", m)
+
+		_, err = c.ResolveModule("example.com/tool")
+		assert.Error(t, err)
+	})
+
+	t.Run("negative", func(t *testing.T) {
+		r := &countingResolver{err: gobinaries.ErrNoVersions}
+		c := &resolver.CachingResolver{
+			Resolver:    r,
+			NegativeTTL: 20 * time.Millisecond,
+		}
+
+		for i := 0; i < 3; i++ {
+			_, err := c.Resolve("golang.org/x/tools", "latest")
+			assert.Equal(t, gobinaries.ErrNoVersions, err)
+		}
+		assert.Equal(t, 1, r.count())
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		r := &countingResolver{err: errors.New("boom")}
+		c := &resolver.CachingResolver{Resolver: r}
+
+		for i := 0; i < 3; i++ {
+			_, err := c.Resolve("golang.org/x/tools", "latest")
+			assert.EqualError(t, err, "boom")
+		}
+		assert.Equal(t, 3, r.count())
+		assert.Equal(t, 0, c.Len())
+	})
+}
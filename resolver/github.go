@@ -3,10 +3,12 @@ package resolver
 import (
 	"context"
 	"fmt"
+	"net/http"
+	"path"
+	"strings"
 	"time"
 
 	"github.com/google/go-github/v28/github"
-	"github.com/tj/go-semver"
 
 	"github.com/tj/gobinaries"
 )
@@ -19,48 +21,97 @@ type GitHub struct {
 }
 
 // Resolve implementation.
-func (g *GitHub) Resolve(owner, repo, version string) (string, error) {
-	// fetch tags
-	tags, err := g.versions(owner, repo)
+func (g *GitHub) Resolve(mod, version string) (string, error) {
+	owner, repo, dir, err := splitModule(mod)
 	if err != nil {
 		return "", err
 	}
 
-	// convert to semver, ignoring malformed
-	var versions []semver.Version
-	for _, t := range tags {
-		if v, err := semver.Parse(t); err == nil {
-			versions = append(versions, v)
-		}
+	return resolveSource(g, owner, repo, dir, version)
+}
+
+// ResolveDeprecation implementation.
+func (g *GitHub) ResolveDeprecation(mod string) (string, error) {
+	owner, repo, dir, err := splitModule(mod)
+	if err != nil {
+		return "", err
 	}
 
-	// no versions, it has tags but they're not semver
-	if len(versions) == 0 {
-		return "", gobinaries.ErrNoVersions
+	return deprecationSource(g, owner, repo, dir)
+}
+
+// ResolvePackage implementation.
+func (g *GitHub) ResolvePackage(mod, pkg, version string) (gobinaries.Package, error) {
+	owner, repo, dir, err := splitModule(mod)
+	if err != nil {
+		return gobinaries.Package{}, err
+	}
+
+	return resolvePackageSource(g, owner, repo, dir, mod, pkg, version)
+}
+
+// ResolveModule implementation.
+func (g *GitHub) ResolveModule(pkg string) (string, error) {
+	owner, repo, _, err := splitModule(pkg)
+	if err != nil {
+		return "", err
 	}
 
-	// master special-case
-	if version == "master" {
-		return versions[0].String(), nil
+	return moduleSource(g, owner, repo, pkg)
+}
+
+// Commit returns the commit of a branch, or a full or abbreviated commit SHA.
+func (g *GitHub) Commit(owner, repo, ref string) (Commit, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	c, res, err := g.Client.Repositories.GetCommit(ctx, owner, repo, ref)
+	if res != nil && (res.StatusCode == http.StatusNotFound || res.StatusCode == http.StatusUnprocessableEntity) {
+		return Commit{}, gobinaries.ErrNoVersionMatch
 	}
 
-	// match requested semver range
-	vr, err := semver.ParseRange(version)
 	if err != nil {
-		return "", fmt.Errorf("parsing version range: %w", err)
+		return Commit{}, fmt.Errorf("getting commit: %w", err)
 	}
 
-	for _, v := range versions {
-		if vr.Match(v) {
-			return v.String(), nil
-		}
+	return Commit{
+		SHA:  c.GetSHA(),
+		Time: c.GetCommit().GetCommitter().GetDate(),
+	}, nil
+}
+
+// GoMod returns the go.mod file in the directory dir of a repository at the given tag or commit.
+func (g *GitHub) GoMod(owner, repo, ref, dir string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	options := &github.RepositoryContentGetOptions{
+		Ref: ref,
+	}
+
+	file, _, res, err := g.Client.Repositories.GetContents(ctx, owner, repo, path.Join(dir, "go.mod"), options)
+	if res != nil && res.StatusCode == http.StatusNotFound {
+		return nil, nil
 	}
 
-	return "", gobinaries.ErrNoVersionMatch
+	if err != nil {
+		return nil, fmt.Errorf("getting contents: %w", err)
+	}
+
+	if file == nil {
+		return nil, nil
+	}
+
+	content, err := file.GetContent()
+	if err != nil {
+		return nil, fmt.Errorf("decoding contents: %w", err)
+	}
+
+	return []byte(content), nil
 }
 
-// versions returns the versions of a repository.
-func (g *GitHub) versions(owner, repo string) (versions []string, err error) {
+// Versions returns the tags of a repository.
+func (g *GitHub) Versions(owner, repo string) (versions []string, err error) {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
 	defer cancel()
 
@@ -94,3 +145,14 @@ func (g *GitHub) versions(owner, repo string) (versions []string, err error) {
 
 	return
 }
+
+// splitModule returns the owner, repository and directory of a GitHub module
+// path such as "github.com/tj/staticgen", or "github.com/tj/tools/cmd/tool"
+// for a module in the "cmd/tool" directory.
+func splitModule(mod string) (owner, repo, dir string, err error) {
+	parts := strings.Split(mod, "/")
+	if len(parts) < 3 || parts[0] != "github.com" {
+		return "", "", "", fmt.Errorf("module %q is not hosted on GitHub", mod)
+	}
+	return parts[1], parts[2], strings.Join(parts[3:], "/"), nil
+}
@@ -33,38 +33,44 @@ func TestGitHub_Resolve(t *testing.T) {
 	r := newResolver()
 
 	t.Run("exact match", func(t *testing.T) {
-		v, err := r.Resolve("tj", "d3-bar", "v1.8.0")
+		v, err := r.Resolve("github.com/tj/d3-bar", "v1.8.0")
 		assert.NoError(t, err)
 		assert.Equal(t, "v1.8.0", v)
 	})
 
 	t.Run("exact match without leading v", func(t *testing.T) {
-		v, err := r.Resolve("tj", "d3-bar", "1.8.0")
+		v, err := r.Resolve("github.com/tj/d3-bar", "1.8.0")
 		assert.NoError(t, err)
 		assert.Equal(t, "v1.8.0", v)
 	})
 
 	t.Run("major wildcard match", func(t *testing.T) {
-		v, err := r.Resolve("tj", "d3-bar", "1.x")
+		v, err := r.Resolve("github.com/tj/d3-bar", "1.x")
 		assert.NoError(t, err)
 		assert.Equal(t, "v1.8.0", v)
 	})
 
 	t.Run("minor wildcard match", func(t *testing.T) {
-		v, err := r.Resolve("tj", "d3-bar", "1.6.x")
+		v, err := r.Resolve("github.com/tj/d3-bar", "1.6.x")
 		assert.NoError(t, err)
 		assert.Equal(t, "v1.6.0", v)
 	})
 
 	t.Run("minor match", func(t *testing.T) {
-		v, err := r.Resolve("tj", "d3-bar", "1.6")
+		v, err := r.Resolve("github.com/tj/d3-bar", "1.6")
 		assert.NoError(t, err)
 		assert.Equal(t, "v1.6.0", v)
 	})
 
-	t.Run("master", func(t *testing.T) {
-		v, err := r.Resolve("tj", "d3-bar", "master")
+	t.Run("latest", func(t *testing.T) {
+		v, err := r.Resolve("github.com/tj/d3-bar", "latest")
 		assert.NoError(t, err)
 		assert.Equal(t, "v1.8.0", v)
 	})
+
+	t.Run("branch", func(t *testing.T) {
+		v, err := r.Resolve("github.com/tj/d3-bar", "master")
+		assert.NoError(t, err)
+		assert.Regexp(t, `^v0\.0\.0-\d{14}-[0-9a-f]{12}$`, v)
+	})
 }
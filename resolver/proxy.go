@@ -0,0 +1,343 @@
+package resolver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/mod/module"
+	modsemver "golang.org/x/mod/semver"
+
+	"github.com/tj/gobinaries"
+)
+
+// errNotFound is returned when the proxy responds with 404 or 410.
+var errNotFound = errors.New("not found")
+
+// maxProxySize is the maximum size of the proxy responses read, such as go.mod files.
+const maxProxySize = 4 << 20
+
+// Proxy is a resolver using the GOPROXY protocol, for
+// modules hosted anywhere, not only on GitHub.
+type Proxy struct {
+	// URL is the proxy URL, defaulting to "https://proxy.golang.org".
+	URL string
+
+	// Client is the HTTP client, defaulting to http.DefaultClient.
+	Client *http.Client
+}
+
+// info is the version information returned by the proxy.
+type info struct {
+	Version string
+	Time    time.Time
+}
+
+// Resolve implementation.
+func (p *Proxy) Resolve(mod, version string) (string, error) {
+	// branch or commit
+	if !isRange(version) {
+		v, err := p.query(mod, version)
+		if err == errNotFound {
+			return "", gobinaries.ErrNoVersionMatch
+		}
+		return v.Version, err
+	}
+
+	versions, err := p.Versions(mod)
+
+	// untagged modules resolve to the latest pseudo-version
+	if err == gobinaries.ErrNoVersions && version == "latest" {
+		v, err := p.query(mod, "latest")
+		if err == errNotFound {
+			return "", gobinaries.ErrNoVersions
+		}
+		return v.Version, err
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	// retracted versions are only resolved when explicitly requested
+	if _, ok := parseVersion(version); !ok {
+		m, err := p.latestGoMod(mod, versions)
+		if err != nil {
+			return "", err
+		}
+
+		versions = m.exclude(versions)
+		if len(versions) == 0 {
+			return "", gobinaries.ErrNoVersionMatch
+		}
+	}
+
+	return resolve(versions, version)
+}
+
+// ResolveDeprecation implementation.
+func (p *Proxy) ResolveDeprecation(mod string) (string, error) {
+	versions, err := p.Versions(mod)
+	if err != nil {
+		return "", err
+	}
+
+	m, err := p.latestGoMod(mod, versions)
+	if err != nil {
+		return "", err
+	}
+
+	return m.deprecated, nil
+}
+
+// ResolvePackage implementation.
+func (p *Proxy) ResolvePackage(mod, pkg, version string) (gobinaries.Package, error) {
+	rel := strings.Trim(strings.TrimPrefix(pkg, mod), "/")
+
+	for dir := rel; ; dir = parentDir(dir) {
+		path := mod
+		if dir != "" {
+			path += "/" + dir
+		}
+
+		for _, c := range moduleCandidates(path, version) {
+			b, err := p.goMod(c.Path, c.Version)
+			if err == errNotFound {
+				continue
+			}
+
+			if err != nil {
+				return gobinaries.Package{}, err
+			}
+
+			m, err := parseGoMod(b)
+			if err != nil {
+				return gobinaries.Package{}, err
+			}
+
+			if m.path == "" {
+				m.path = c.Path
+			}
+
+			return newPackage(m.path, strings.Trim(strings.TrimPrefix(rel, dir), "/"), c.Version), nil
+		}
+
+		if dir == "" {
+			return gobinaries.Package{}, gobinaries.ErrNoVersionMatch
+		}
+	}
+}
+
+// ResolveModule implementation. The module is the longest path prefix of the
+// package known to the proxy, in the same way as `go get` finds the module.
+func (p *Proxy) ResolveModule(pkg string) (string, error) {
+	for mod := pkg; mod != ""; mod = parentDir(mod) {
+		if module.CheckPath(mod) != nil {
+			continue
+		}
+
+		versions, err := p.list(mod)
+		if err == errNotFound {
+			continue
+		}
+
+		if err != nil {
+			return "", err
+		}
+
+		if len(versions) > 0 {
+			return mod, nil
+		}
+
+		// untagged modules have a latest pseudo-version
+		_, err = p.query(mod, "latest")
+		if err == errNotFound {
+			continue
+		}
+
+		if err != nil {
+			return "", err
+		}
+
+		return mod, nil
+	}
+
+	return "", fmt.Errorf("no module provides package %q", pkg)
+}
+
+// moduleCandidates returns the module paths and versions which may provide
+// the version of a module path, such as "example.com/mod/v2" at "v2.0.0",
+// or "example.com/mod" at "v2.0.0+incompatible".
+func moduleCandidates(path, version string) []module.Version {
+	_, pathMajor, ok := module.SplitPathVersion(path)
+	if !ok || pathMajor != "" || module.CheckPathMajor(version, "") == nil {
+		return []module.Version{{Path: path, Version: version}}
+	}
+
+	return []module.Version{
+		{Path: path + "/" + modsemver.Major(version), Version: version},
+		{Path: path, Version: version + "+incompatible"},
+	}
+}
+
+// Versions returns the versions of a module, including
+// versions of its major version modules such as "/v2".
+func (p *Proxy) Versions(mod string) (versions []string, err error) {
+	versions, err = p.list(mod)
+	if err != nil && err != errNotFound {
+		return nil, err
+	}
+
+	for major := 2; ; major++ {
+		list, err := p.list(fmt.Sprintf("%s/v%d", mod, major))
+		if err == errNotFound || (err == nil && len(list) == 0) {
+			break
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		versions = append(versions, list...)
+	}
+
+	if len(versions) == 0 {
+		return nil, gobinaries.ErrNoVersions
+	}
+
+	return
+}
+
+// latestGoMod returns the go.mod file of the latest version of a module.
+func (p *Proxy) latestGoMod(mod string, versions []string) (goMod, error) {
+	latest, err := resolve(versions, "latest")
+	if err != nil {
+		return goMod{}, nil
+	}
+
+	// versions of major version modules such as "/v2"
+	c := moduleCandidates(mod, latest)[0]
+
+	b, err := p.goMod(c.Path, c.Version)
+	if err == errNotFound {
+		return goMod{}, nil
+	}
+
+	if err != nil {
+		return goMod{}, fmt.Errorf("getting go.mod: %w", err)
+	}
+
+	return parseGoMod(b)
+}
+
+// goMod returns the go.mod file of a module version.
+func (p *Proxy) goMod(mod, version string) ([]byte, error) {
+	escaped, err := module.EscapeVersion(version)
+	if err != nil {
+		return nil, fmt.Errorf("escaping version: %w", err)
+	}
+
+	return p.get(mod, "@v/"+escaped+".mod")
+}
+
+// list returns the tagged versions of a module.
+func (p *Proxy) list(mod string) (versions []string, err error) {
+	b, err := p.get(mod, "@v/list")
+	if err != nil {
+		return nil, err
+	}
+
+	for _, line := range strings.Split(string(b), "\n") {
+		if v := strings.TrimSpace(line); v != "" {
+			versions = append(versions, v)
+		}
+	}
+
+	return
+}
+
+// query returns the version information of a module query
+// such as "latest", a branch or a commit.
+func (p *Proxy) query(mod, query string) (v info, err error) {
+	path := "@latest"
+
+	if query != "latest" {
+		escaped, err := module.EscapeVersion(query)
+		if err != nil {
+			return v, errNotFound
+		}
+		path = "@v/" + escaped + ".info"
+	}
+
+	b, err := p.get(mod, path)
+	if err != nil {
+		return v, err
+	}
+
+	err = json.Unmarshal(b, &v)
+	if err != nil {
+		return v, fmt.Errorf("parsing info: %w", err)
+	}
+
+	return
+}
+
+// get returns the response body of a proxy request for the module.
+func (p *Proxy) get(mod, path string) ([]byte, error) {
+	escaped, err := module.EscapePath(mod)
+	if err != nil {
+		return nil, fmt.Errorf("escaping module path: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	req, err := http.NewRequest("GET", p.url()+"/"+escaped+"/"+path, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	res, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("requesting: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound || res.StatusCode == http.StatusGone {
+		return nil, errNotFound
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("proxy responded with %s", res.Status)
+	}
+
+	b, err := ioutil.ReadAll(io.LimitReader(res.Body, maxProxySize+1))
+	if err != nil {
+		return nil, fmt.Errorf("reading: %w", err)
+	}
+
+	if len(b) > maxProxySize {
+		return nil, fmt.Errorf("proxy response exceeds %d bytes", maxProxySize)
+	}
+
+	return b, nil
+}
+
+// url returns the proxy URL.
+func (p *Proxy) url() string {
+	if p.URL == "" {
+		return "https://proxy.golang.org"
+	}
+	return strings.TrimSuffix(p.URL, "/")
+}
@@ -0,0 +1,269 @@
+package resolver_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/tj/assert"
+
+	"github.com/tj/gobinaries"
+	"github.com/tj/gobinaries/resolver"
+)
+
+// newProxy returns a module proxy serving the given paths.
+func newProxy(paths map[string]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := paths[r.URL.Path]
+		if !ok {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		fmt.Fprint(w, body)
+	}))
+}
+
+// Test proxy resolution.
+func TestProxy_Resolve(t *testing.T) {
+	proxy := newProxy(map[string]string{
+		"/example.com/tool/@v/list":             "v1.0.0\nv1.1.0\nv1.2.0-rc.1\n",
+		"/example.com/tool/v2/@v/list":          "v2.0.0\n",
+		"/example.com/tool/v3/@v/list":          "",
+		"/example.com/tool/@v/master.info":      `{"Version":"v2.0.1-0.20200101120000-abcdef123456","Time":"2020-01-01T12:00:00Z"}`,
+		"/example.com/!upper/@v/list":           "v0.1.0\n",
+		"/example.com/untagged/@v/list":         "",
+		"/example.com/untagged/@latest":         `{"Version":"v0.0.0-20200101120000-abcdef123456","Time":"2020-01-01T12:00:00Z"}`,
+		"/example.com/untagged/@v/abcdef1.info": `{"Version":"v0.0.0-20200101120000-abcdef123456","Time":"2020-01-01T12:00:00Z"}`,
+	})
+	defer proxy.Close()
+
+	r := &resolver.Proxy{
+		URL: proxy.URL,
+	}
+
+	cases := []struct {
+		mod      string
+		version  string
+		expected string
+		err      error
+	}{
+		{"example.com/tool", "latest", "v2.0.0", nil},
+		{"example.com/tool", "1.x", "v1.1.0", nil},
+		{"example.com/tool", "v1.2.0-rc.1", "v1.2.0-rc.1", nil},
+		{"example.com/tool", "3.x", "", gobinaries.ErrNoVersionMatch},
+		{"example.com/tool", "master", "v2.0.1-0.20200101120000-abcdef123456", nil},
+		{"example.com/tool", "missing", "", gobinaries.ErrNoVersionMatch},
+		{"example.com/Upper", "latest", "v0.1.0", nil},
+		{"example.com/untagged", "latest", "v0.0.0-20200101120000-abcdef123456", nil},
+		{"example.com/untagged", "abcdef1", "v0.0.0-20200101120000-abcdef123456", nil},
+		{"example.com/untagged", "1.x", "", gobinaries.ErrNoVersions},
+		{"example.com/missing", "latest", "", gobinaries.ErrNoVersions},
+	}
+
+	for _, c := range cases {
+		t.Run(c.mod+"@"+c.version, func(t *testing.T) {
+			v, err := r.Resolve(c.mod, c.version)
+			assert.Equal(t, c.err, err)
+			assert.Equal(t, c.expected, v)
+		})
+	}
+}
+
+// Test proxy errors.
+func TestProxy_Resolve_error(t *testing.T) {
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer proxy.Close()
+
+	r := &resolver.Proxy{
+		URL: proxy.URL,
+	}
+
+	_, err := r.Resolve("example.com/tool", "latest")
+	assert.Error(t, err)
+	assert.True(t, strings.Contains(err.Error(), "503"))
+}
+
+// Test that large proxy responses are rejected.
+func TestProxy_Resolve_large(t *testing.T) {
+	proxy := newProxy(map[string]string{
+		"/example.com/tool/@v/list": strings.Repeat("v1.0.0\n", 1<<20),
+	})
+	defer proxy.Close()
+
+	r := &resolver.Proxy{
+		URL: proxy.URL,
+	}
+
+	_, err := r.Resolve("example.com/tool", "latest")
+	assert.Error(t, err)
+	assert.True(t, strings.Contains(err.Error(), "exceeds"))
+}
+
+// Test proxy resolution with retracted versions and deprecations.
+func TestProxy_Resolve_retract(t *testing.T) {
+	proxy := newProxy(map[string]string{
+		"/example.com/tool/@v/list":           "v1.0.0\nv1.1.0\nv1.2.0\n",
+		"/example.com/tool/@v/v1.2.0.mod":     "// Deprecated: use example.com/other\nmodule example.com/tool\n\nretract [v1.1.0, v1.2.0]\n",
+		"/example.com/major/@v/list":          "v1.0.0\n",
+		"/example.com/major/v2/@v/list":       "v2.0.0\nv2.1.0\n",
+		"/example.com/major/v2/@v/v2.1.0.mod": "module example.com/major/v2\n\nretract v2.1.0\n",
+	})
+	defer proxy.Close()
+
+	r := &resolver.Proxy{
+		URL: proxy.URL,
+	}
+
+	v, err := r.Resolve("example.com/tool", "latest")
+	assert.NoError(t, err)
+	assert.Equal(t, "v1.0.0", v)
+
+	v, err = r.Resolve("example.com/tool", "v1.1.0")
+	assert.NoError(t, err)
+	assert.Equal(t, "v1.1.0", v)
+
+	v, err = r.Resolve("example.com/major", "latest")
+	assert.NoError(t, err)
+	assert.Equal(t, "v2.0.0", v)
+
+	msg, err := r.ResolveDeprecation("example.com/tool")
+	assert.NoError(t, err)
+	assert.Equal(t, "use example.com/other", msg)
+
+	msg, err = r.ResolveDeprecation("example.com/major")
+	assert.NoError(t, err)
+	assert.Equal(t, "", msg)
+}
+
+// Test resolving packages from the proxy's go.mod files.
+func TestProxy_ResolvePackage(t *testing.T) {
+	proxy := newProxy(map[string]string{
+		"/example.com/tool/@v/v1.2.0.mod":                "module example.com/tool\n",
+		"/example.com/tool/v2/@v/v2.1.0.mod":             "module example.com/tool/v2\n",
+		"/example.com/legacy/@v/v2.0.0+incompatible.mod": "module example.com/legacy\n",
+		"/example.com/mono/@v/v1.0.0.mod":                "module example.com/mono\n",
+		"/example.com/mono/tools/@v/v1.0.0.mod":          "module example.com/mono/tools\n",
+		"/example.com/mono/tools/v3/@v/v3.0.0.mod":       "module example.com/mono/tools/v3\n",
+		"/example.com/!upper/@v/v1.0.0.mod":              "module example.com/Upper\n",
+	})
+	defer proxy.Close()
+
+	r := &resolver.Proxy{
+		URL: proxy.URL,
+	}
+
+	cases := []struct {
+		name     string
+		mod      string
+		pkg      string
+		version  string
+		expected gobinaries.Package
+	}{
+		{"v1", "example.com/tool", "example.com/tool/cmd/tool", "v1.2.0", gobinaries.Package{
+			Path:    "example.com/tool/cmd/tool",
+			Module:  "example.com/tool",
+			Version: "v1.2.0",
+		}},
+		{"v2", "example.com/tool", "example.com/tool/cmd/tool", "v2.1.0", gobinaries.Package{
+			Path:    "example.com/tool/v2/cmd/tool",
+			Module:  "example.com/tool/v2",
+			Version: "v2.1.0",
+		}},
+		{"v2 requested with suffix", "example.com/tool", "example.com/tool/v2/cmd/tool", "v2.1.0", gobinaries.Package{
+			Path:    "example.com/tool/v2/cmd/tool",
+			Module:  "example.com/tool/v2",
+			Version: "v2.1.0",
+		}},
+		{"incompatible", "example.com/legacy", "example.com/legacy", "v2.0.0+incompatible", gobinaries.Package{
+			Path:    "example.com/legacy",
+			Module:  "example.com/legacy",
+			Version: "v2.0.0+incompatible",
+		}},
+		{"incompatible without build metadata", "example.com/legacy", "example.com/legacy", "v2.0.0", gobinaries.Package{
+			Path:    "example.com/legacy",
+			Module:  "example.com/legacy",
+			Version: "v2.0.0+incompatible",
+		}},
+		{"nested", "example.com/mono", "example.com/mono/tools/cmd/lint", "v1.0.0", gobinaries.Package{
+			Path:    "example.com/mono/tools/cmd/lint",
+			Module:  "example.com/mono/tools",
+			Version: "v1.0.0",
+		}},
+		{"nested v3", "example.com/mono", "example.com/mono/tools/cmd/lint", "v3.0.0", gobinaries.Package{
+			Path:    "example.com/mono/tools/v3/cmd/lint",
+			Module:  "example.com/mono/tools/v3",
+			Version: "v3.0.0",
+		}},
+		{"escaped", "example.com/Upper", "example.com/Upper", "v1.0.0", gobinaries.Package{
+			Path:    "example.com/Upper",
+			Module:  "example.com/Upper",
+			Version: "v1.0.0",
+		}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			p, err := r.ResolvePackage(c.mod, c.pkg, c.version)
+			assert.NoError(t, err)
+			assert.Equal(t, c.expected, p)
+		})
+	}
+
+	t.Run("missing", func(t *testing.T) {
+		_, err := r.ResolvePackage("example.com/tool", "example.com/tool/cmd/tool", "v9.0.0")
+		assert.Equal(t, gobinaries.ErrNoVersionMatch, err)
+	})
+}
+
+// Test finding the module of a package with the proxy.
+func TestProxy_ResolveModule(t *testing.T) {
+	proxy := newProxy(map[string]string{
+		"/go.uber.org/zap/@v/list":               "v1.0.0\n",
+		"/golang.org/x/tools/@v/list":            "v0.1.0\n",
+		"/golang.org/x/tools/gopls/@v/list":      "v0.6.0\n",
+		"/example.com/tool/v2/@v/list":           "v2.0.0\n",
+		"/example.com/untagged/@v/list":          "",
+		"/example.com/untagged/@latest":          `{"Version":"v0.0.0-20200101120000-abcdef123456","Time":"2020-01-01T12:00:00Z"}`,
+		"/example.com/nested/@v/list":            "",
+		"/example.com/nested/cmd/nested/@v/list": "",
+	})
+	defer proxy.Close()
+
+	r := &resolver.Proxy{
+		URL: proxy.URL,
+	}
+
+	cases := []struct {
+		pkg      string
+		expected string
+	}{
+		{"go.uber.org/zap", "go.uber.org/zap"},
+		{"go.uber.org/zap/cmd/zap", "go.uber.org/zap"},
+		{"golang.org/x/tools/cmd/stringer", "golang.org/x/tools"},
+		{"golang.org/x/tools/gopls", "golang.org/x/tools/gopls"},
+		{"example.com/tool/v2/cmd/tool", "example.com/tool/v2"},
+		{"example.com/untagged/cmd/untagged", "example.com/untagged"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.pkg, func(t *testing.T) {
+			mod, err := r.ResolveModule(c.pkg)
+			assert.NoError(t, err)
+			assert.Equal(t, c.expected, mod)
+		})
+	}
+
+	t.Run("missing", func(t *testing.T) {
+		_, err := r.ResolveModule("example.com/missing/cmd/missing")
+		assert.Error(t, err)
+	})
+
+	t.Run("untagged without a version", func(t *testing.T) {
+		_, err := r.ResolveModule("example.com/nested/cmd/nested")
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,381 @@
+package resolver
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tj/gobinaries"
+)
+
+// knownHosts are code hosts where repositories are always
+// in the form "<host>/<owner>/<repo>".
+var knownHosts = map[string]bool{
+	"github.com":    true,
+	"bitbucket.org": true,
+}
+
+// Registry is a resolver delegating to the resolver registered for the host
+// of each module. The module of a vanity import path such as "golang.org/x/tools"
+// is found using its go-import meta tag.
+type Registry struct {
+	// Hosts is a map of hosts such as "github.com" to their resolver.
+	Hosts map[string]gobinaries.Resolver
+
+	// Default is the resolver used for other hosts, such as a Proxy.
+	Default gobinaries.Resolver
+
+	// Client is the HTTP client used for discovery, defaulting to http.DefaultClient.
+	Client *http.Client
+
+	// DiscoveryTTL is the duration discovered go-import meta tags are cached for,
+	// defaulting to 1 hour. Discovery failures are cached for 1 minute.
+	DiscoveryTTL time.Duration
+
+	mu          sync.Mutex
+	discoveries map[string]discovery
+}
+
+// discovery is a cached go-import meta tag, keyed by its import prefix,
+// or a discovery failure, keyed by the package path discovered.
+type discovery struct {
+	imp     Import
+	err     error
+	expires time.Time
+}
+
+// maxDiscoveries is the maximum number of cached discoveries.
+const maxDiscoveries = 10000
+
+// maxDiscoverySize is the maximum size of the documents read by discovery.
+const maxDiscoverySize = 1 << 20
+
+// Import is a go-import meta tag.
+type Import struct {
+	// Prefix is the import path of the repository root such as "golang.org/x/tools".
+	Prefix string
+
+	// VCS is the version control system such as "git", or "mod" for a module proxy.
+	VCS string
+
+	// RepoRoot is the repository URL such as "https://go.googlesource.com/tools".
+	RepoRoot string
+}
+
+// Resolve implementation.
+func (r *Registry) Resolve(mod, version string) (string, error) {
+	res, mod, err := r.resolver(mod)
+	if err != nil {
+		return "", err
+	}
+
+	return res.Resolve(mod, version)
+}
+
+// ResolveDeprecation implementation.
+func (r *Registry) ResolveDeprecation(mod string) (string, error) {
+	res, mod, err := r.resolver(mod)
+	if err != nil {
+		return "", err
+	}
+
+	if d, ok := res.(gobinaries.DeprecationResolver); ok {
+		return d.ResolveDeprecation(mod)
+	}
+
+	return "", nil
+}
+
+// ResolvePackage implementation.
+func (r *Registry) ResolvePackage(mod, pkg, version string) (gobinaries.Package, error) {
+	res, resolved, err := r.resolver(mod)
+	if err != nil {
+		return gobinaries.Package{}, err
+	}
+
+	p, ok := res.(gobinaries.PackageResolver)
+	if !ok {
+		return gobinaries.Package{}, fmt.Errorf("resolving packages of %s is not supported", host(mod))
+	}
+
+	return p.ResolvePackage(resolved, resolved+strings.TrimPrefix(pkg, mod), version)
+}
+
+// resolver returns the resolver for the module, and the module path it resolves.
+func (r *Registry) resolver(mod string) (gobinaries.Resolver, string, error) {
+	if res, ok := r.Hosts[host(mod)]; ok {
+		return res, mod, nil
+	}
+
+	// vanity import path of a registered host
+	imp, err := r.Discover(mod)
+	if err == nil {
+		if res, root, ok := r.hostResolver(imp); ok {
+			return res, root + strings.TrimPrefix(mod, imp.Prefix), nil
+		}
+	}
+
+	if r.Default != nil {
+		return r.Default, mod, nil
+	}
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	return nil, "", fmt.Errorf("%w: %s", gobinaries.ErrUnsupportedHost, host(mod))
+}
+
+// hostResolver returns the registered resolver of a repository hosted by a
+// registered host, and the repository root path it resolves, such as
+// "github.com/golang/tools" for the "golang.org/x/tools" import prefix.
+func (r *Registry) hostResolver(imp Import) (gobinaries.Resolver, string, bool) {
+	if imp.VCS != "git" {
+		return nil, "", false
+	}
+
+	u, err := url.Parse(imp.RepoRoot)
+	if err != nil {
+		return nil, "", false
+	}
+
+	res, ok := r.Hosts[u.Host]
+	if !ok {
+		return nil, "", false
+	}
+
+	return res, u.Host + strings.TrimSuffix(strings.TrimSuffix(u.Path, "/"), ".git"), true
+}
+
+// ResolveModule implementation. The module is the deepest module of the package
+// within its repository, found by the resolver of the repository's host, or the
+// Default resolver, otherwise it is the repository root.
+func (r *Registry) ResolveModule(pkg string) (string, error) {
+	if m, ok := r.Hosts[host(pkg)].(gobinaries.ModuleResolver); ok {
+		return m.ResolveModule(pkg)
+	}
+
+	imp, err := r.repository(pkg)
+	if err != nil {
+		return "", err
+	}
+
+	// vanity import path of a registered host, where the module
+	// found is mapped back to the vanity import path
+	if res, root, ok := r.hostResolver(imp); ok {
+		if m, ok := res.(gobinaries.ModuleResolver); ok {
+			mod, err := m.ResolveModule(root + strings.TrimPrefix(pkg, imp.Prefix))
+			if err != nil {
+				return "", err
+			}
+
+			if mod == root || strings.HasPrefix(mod, root+"/") {
+				return imp.Prefix + strings.TrimPrefix(mod, root), nil
+			}
+		}
+	}
+
+	// modules nested within the repository, such as "golang.org/x/tools/gopls"
+	if m, ok := r.Default.(gobinaries.ModuleResolver); ok {
+		return m.ResolveModule(pkg)
+	}
+
+	return imp.Prefix, nil
+}
+
+// repository returns the go-import meta tag of the package's repository,
+// which is not discovered for hosts where the repository root is known.
+func (r *Registry) repository(pkg string) (Import, error) {
+	parts := strings.Split(pkg, "/")
+
+	if knownHosts[parts[0]] {
+		if len(parts) < 3 {
+			return Import{}, fmt.Errorf("invalid package path %q", pkg)
+		}
+		return Import{Prefix: strings.Join(parts[:3], "/")}, nil
+	}
+
+	return r.Discover(pkg)
+}
+
+// Discover returns the go-import meta tag for the package, requested with "?go-get=1".
+// Meta tags are cached by import prefix, so that the packages of a module are
+// discovered with a single request.
+func (r *Registry) Discover(pkg string) (Import, error) {
+	if d, ok := r.cachedDiscovery(pkg); ok {
+		return d.imp, d.err
+	}
+
+	imp, err := r.discover(pkg)
+
+	d := discovery{imp: imp, err: err, expires: time.Now().Add(time.Minute)}
+	key := pkg
+	if err == nil {
+		d.expires = time.Now().Add(r.discoveryTTL())
+		key = imp.Prefix
+	}
+	r.cacheDiscovery(key, d)
+
+	return imp, err
+}
+
+// cachedDiscovery returns the cached go-import meta tag of the package, the
+// tag of the longest import prefix of the package, or its cached failure.
+func (r *Registry) cachedDiscovery(pkg string) (discovery, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	for p := pkg; ; {
+		d, ok := r.discoveries[p]
+		if ok && now.Before(d.expires) && (d.err == nil || p == pkg) {
+			return d, true
+		}
+
+		i := strings.LastIndex(p, "/")
+		if i == -1 {
+			return discovery{}, false
+		}
+		p = p[:i]
+	}
+}
+
+// cacheDiscovery caches a discovery, removing expired discoveries when full.
+func (r *Registry) cacheDiscovery(key string, d discovery) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.discoveries == nil {
+		r.discoveries = make(map[string]discovery)
+	}
+
+	if len(r.discoveries) >= maxDiscoveries {
+		now := time.Now()
+		for k, v := range r.discoveries {
+			if !now.Before(v.expires) {
+				delete(r.discoveries, k)
+			}
+		}
+	}
+
+	if len(r.discoveries) < maxDiscoveries {
+		r.discoveries[key] = d
+	}
+}
+
+// discoveryTTL returns the duration discoveries are cached for.
+func (r *Registry) discoveryTTL() time.Duration {
+	if r.DiscoveryTTL > 0 {
+		return r.DiscoveryTTL
+	}
+	return time.Hour
+}
+
+// discover requests the go-import meta tag for the package.
+func (r *Registry) discover(pkg string) (Import, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	req, err := http.NewRequest("GET", "https://"+pkg+"?go-get=1", nil)
+	if err != nil {
+		return Import{}, err
+	}
+
+	client := r.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	res, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return Import{}, fmt.Errorf("requesting: %w", err)
+	}
+	defer res.Body.Close()
+
+	imports, err := parseImports(io.LimitReader(res.Body, maxDiscoverySize))
+	if err != nil {
+		return Import{}, fmt.Errorf("parsing go-import meta tags: %w", err)
+	}
+
+	// the longest matching prefix wins
+	var match Import
+	for _, imp := range imports {
+		if pkg == imp.Prefix || strings.HasPrefix(pkg, imp.Prefix+"/") {
+			if len(imp.Prefix) > len(match.Prefix) {
+				match = imp
+			}
+		}
+	}
+
+	if match.Prefix == "" {
+		return Import{}, fmt.Errorf("%w: %s", gobinaries.ErrUnsupportedHost, host(pkg))
+	}
+
+	return match, nil
+}
+
+// parseImports returns the go-import meta tags of an HTML document.
+func parseImports(r io.Reader) (imports []Import, err error) {
+	d := xml.NewDecoder(r)
+	d.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		return input, nil
+	}
+	d.Strict = false
+
+	for {
+		t, err := d.RawToken()
+		if err == io.EOF {
+			return imports, nil
+		}
+
+		if err != nil {
+			if len(imports) > 0 {
+				return imports, nil
+			}
+			return nil, err
+		}
+
+		// meta tags are in the head
+		if e, ok := t.(xml.StartElement); ok && strings.EqualFold(e.Name.Local, "body") {
+			return imports, nil
+		}
+
+		if e, ok := t.(xml.EndElement); ok && strings.EqualFold(e.Name.Local, "head") {
+			return imports, nil
+		}
+
+		e, ok := t.(xml.StartElement)
+		if !ok || !strings.EqualFold(e.Name.Local, "meta") || attr(e, "name") != "go-import" {
+			continue
+		}
+
+		if f := strings.Fields(attr(e, "content")); len(f) == 3 {
+			imports = append(imports, Import{
+				Prefix:   f[0],
+				VCS:      f[1],
+				RepoRoot: f[2],
+			})
+		}
+	}
+}
+
+// attr returns the value of an element's attribute.
+func attr(e xml.StartElement, name string) string {
+	for _, a := range e.Attr {
+		if strings.EqualFold(a.Name.Local, name) {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// host returns the host of a module path.
+func host(mod string) string {
+	return strings.SplitN(mod, "/", 2)[0]
+}
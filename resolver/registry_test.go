@@ -0,0 +1,328 @@
+package resolver_test
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/tj/assert"
+
+	"github.com/tj/gobinaries"
+	"github.com/tj/gobinaries/resolver"
+)
+
+// fakeResolver is a resolver recording the modules resolved.
+type fakeResolver struct {
+	name    string
+	modules []string
+}
+
+// Resolve implementation.
+func (f *fakeResolver) Resolve(mod, version string) (string, error) {
+	f.modules = append(f.modules, mod)
+	return f.name + ":" + version, nil
+}
+
+// fakeModuleResolver is a resolver finding the deepest of its modules providing a package.
+type fakeModuleResolver struct {
+	fakeResolver
+	provides []string
+}
+
+// ResolveModule implementation.
+func (f *fakeModuleResolver) ResolveModule(pkg string) (string, error) {
+	found := ""
+	for _, mod := range f.provides {
+		if (pkg == mod || strings.HasPrefix(pkg, mod+"/")) && len(mod) > len(found) {
+			found = mod
+		}
+	}
+
+	if found == "" {
+		return "", fmt.Errorf("no module provides package %q", pkg)
+	}
+
+	return found, nil
+}
+
+// newVanity returns a server responding with go-import meta tags,
+// where repo is the repository root of the "/tool" import path.
+func newVanity(repo string) *httptest.Server {
+	return newCountingVanity(repo, new(int32))
+}
+
+// newCountingVanity returns a vanity server counting its requests in n.
+func newCountingVanity(repo string, n *int32) *httptest.Server {
+	return httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(n, 1)
+		if r.URL.Query().Get("go-get") != "1" || !strings.HasPrefix(r.URL.Path, "/tool") {
+			http.NotFound(w, r)
+			return
+		}
+
+		fmt.Fprintf(w, `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="go-import" content="%s/tool git %s">
+<meta name="go-source" content="%s/tool _ _ _">
+</head>
+<body>Nothing to see here.</body>
+</html>`, r.Host, repo, r.Host)
+	}))
+}
+
+// Test resolving with a registry.
+func TestRegistry_Resolve(t *testing.T) {
+	t.Run("registered host", func(t *testing.T) {
+		gh := &fakeResolver{name: "github"}
+		r := &resolver.Registry{
+			Hosts: map[string]gobinaries.Resolver{
+				"github.com": gh,
+			},
+		}
+
+		v, err := r.Resolve("github.com/tj/staticgen", "1.x")
+		assert.NoError(t, err, "resolve")
+		assert.Equal(t, "github:1.x", v)
+		assert.Equal(t, []string{"github.com/tj/staticgen"}, gh.modules)
+	})
+
+	t.Run("vanity import path of a registered host", func(t *testing.T) {
+		vanity := newVanity("https://github.com/tj/tool.git")
+		defer vanity.Close()
+
+		gh := &fakeResolver{name: "github"}
+		r := &resolver.Registry{
+			Hosts: map[string]gobinaries.Resolver{
+				"github.com": gh,
+			},
+			Default: &fakeResolver{name: "proxy"},
+			Client:  vanity.Client(),
+		}
+
+		mod := strings.TrimPrefix(vanity.URL, "https://") + "/tool"
+		v, err := r.Resolve(mod, "latest")
+		assert.NoError(t, err, "resolve")
+		assert.Equal(t, "github:latest", v)
+		assert.Equal(t, []string{"github.com/tj/tool"}, gh.modules)
+	})
+
+	t.Run("other host", func(t *testing.T) {
+		vanity := newVanity("https://gitea.example.com/tj/tool")
+		defer vanity.Close()
+
+		proxy := &fakeResolver{name: "proxy"}
+		r := &resolver.Registry{
+			Hosts: map[string]gobinaries.Resolver{
+				"github.com": &fakeResolver{name: "github"},
+			},
+			Default: proxy,
+			Client:  vanity.Client(),
+		}
+
+		mod := strings.TrimPrefix(vanity.URL, "https://") + "/tool"
+		v, err := r.Resolve(mod, "latest")
+		assert.NoError(t, err, "resolve")
+		assert.Equal(t, "proxy:latest", v)
+		assert.Equal(t, []string{mod}, proxy.modules)
+	})
+
+	t.Run("unsupported host", func(t *testing.T) {
+		vanity := newVanity("https://gitea.example.com/tj/tool")
+		defer vanity.Close()
+
+		r := &resolver.Registry{
+			Client: vanity.Client(),
+		}
+
+		mod := strings.TrimPrefix(vanity.URL, "https://") + "/tool"
+		_, err := r.Resolve(mod, "latest")
+		assert.True(t, errors.Is(err, gobinaries.ErrUnsupportedHost))
+	})
+}
+
+// Test resolving the module of a package.
+func TestRegistry_ResolveModule(t *testing.T) {
+	vanity := newVanity("https://github.com/tj/tool")
+	defer vanity.Close()
+
+	r := &resolver.Registry{
+		Client: vanity.Client(),
+	}
+
+	host := strings.TrimPrefix(vanity.URL, "https://")
+
+	cases := []struct {
+		pkg string
+		mod string
+	}{
+		{"github.com/tj/staticgen", "github.com/tj/staticgen"},
+		{"github.com/tj/staticgen/cmd/staticgen", "github.com/tj/staticgen"},
+		{"bitbucket.org/tj/tool", "bitbucket.org/tj/tool"},
+		{host + "/tool", host + "/tool"},
+		{host + "/tool/cmd/tool", host + "/tool"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.pkg, func(t *testing.T) {
+			mod, err := r.ResolveModule(c.pkg)
+			assert.NoError(t, err, "resolve")
+			assert.Equal(t, c.mod, mod)
+		})
+	}
+
+	t.Run("without go-import", func(t *testing.T) {
+		_, err := r.ResolveModule(host + "/other")
+		assert.True(t, errors.Is(err, gobinaries.ErrUnsupportedHost))
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := r.ResolveModule("github.com/tj")
+		assert.Error(t, err)
+	})
+}
+
+// Test resolving the module of a package nested within a repository.
+func TestRegistry_ResolveModule_nested(t *testing.T) {
+	t.Run("vanity import path of a registered host", func(t *testing.T) {
+		vanity := newVanity("https://github.com/tj/tool.git")
+		defer vanity.Close()
+
+		r := &resolver.Registry{
+			Hosts: map[string]gobinaries.Resolver{
+				"github.com": &fakeModuleResolver{
+					fakeResolver: fakeResolver{name: "github"},
+					provides:     []string{"github.com/tj/tool", "github.com/tj/tool/gopls"},
+				},
+			},
+			Client: vanity.Client(),
+		}
+
+		host := strings.TrimPrefix(vanity.URL, "https://")
+
+		mod, err := r.ResolveModule(host + "/tool/gopls/cmd/gopls")
+		assert.NoError(t, err)
+		assert.Equal(t, host+"/tool/gopls", mod)
+
+		mod, err = r.ResolveModule(host + "/tool/cmd/tool")
+		assert.NoError(t, err)
+		assert.Equal(t, host+"/tool", mod)
+	})
+
+	t.Run("other host", func(t *testing.T) {
+		vanity := newVanity("https://gitea.example.com/tj/tool")
+		defer vanity.Close()
+
+		host := strings.TrimPrefix(vanity.URL, "https://")
+
+		r := &resolver.Registry{
+			Default: &fakeModuleResolver{
+				fakeResolver: fakeResolver{name: "proxy"},
+				provides:     []string{host + "/tool", host + "/tool/gopls", "github.com/tj/staticgen/v2"},
+			},
+			Client: vanity.Client(),
+		}
+
+		mod, err := r.ResolveModule(host + "/tool/gopls")
+		assert.NoError(t, err)
+		assert.Equal(t, host+"/tool/gopls", mod)
+
+		mod, err = r.ResolveModule("github.com/tj/staticgen/v2/cmd/staticgen")
+		assert.NoError(t, err)
+		assert.Equal(t, "github.com/tj/staticgen/v2", mod)
+	})
+
+	t.Run("sub-directory of a vanity import path", func(t *testing.T) {
+		vanity := newVanity("https://github.com/tj/tool.git")
+		defer vanity.Close()
+
+		gh := &fakeResolver{name: "github"}
+		r := &resolver.Registry{
+			Hosts: map[string]gobinaries.Resolver{
+				"github.com": gh,
+			},
+			Client: vanity.Client(),
+		}
+
+		host := strings.TrimPrefix(vanity.URL, "https://")
+		_, err := r.Resolve(host+"/tool/gopls", "latest")
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"github.com/tj/tool/gopls"}, gh.modules)
+	})
+}
+
+// Test caching discoveries by import prefix.
+func TestRegistry_Discover(t *testing.T) {
+	t.Run("cached", func(t *testing.T) {
+		var n int32
+		vanity := newCountingVanity("https://github.com/tj/tool", &n)
+		defer vanity.Close()
+
+		gh := &fakeResolver{name: "github"}
+		r := &resolver.Registry{
+			Hosts: map[string]gobinaries.Resolver{
+				"github.com": gh,
+			},
+			Client: vanity.Client(),
+		}
+
+		host := strings.TrimPrefix(vanity.URL, "https://")
+
+		mod, err := r.ResolveModule(host + "/tool/cmd/tool")
+		assert.NoError(t, err)
+		assert.Equal(t, host+"/tool", mod)
+
+		_, err = r.Resolve(mod, "latest")
+		assert.NoError(t, err)
+
+		_, err = r.ResolveDeprecation(mod)
+		assert.NoError(t, err)
+
+		_, err = r.ResolvePackage(mod, host+"/tool/cmd/tool", "v1.0.0")
+		assert.Error(t, err)
+
+		imp, err := r.Discover(host + "/tool/other")
+		assert.NoError(t, err)
+		assert.Equal(t, host+"/tool", imp.Prefix)
+
+		assert.Equal(t, int32(1), atomic.LoadInt32(&n))
+	})
+
+	t.Run("failures", func(t *testing.T) {
+		var n int32
+		vanity := newCountingVanity("https://github.com/tj/tool", &n)
+		defer vanity.Close()
+
+		r := &resolver.Registry{
+			Client: vanity.Client(),
+		}
+
+		host := strings.TrimPrefix(vanity.URL, "https://")
+		for i := 0; i < 3; i++ {
+			_, err := r.Discover(host + "/other")
+			assert.True(t, errors.Is(err, gobinaries.ErrUnsupportedHost))
+		}
+		assert.Equal(t, int32(1), atomic.LoadInt32(&n))
+	})
+
+	t.Run("size limit", func(t *testing.T) {
+		vanity := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, "<html><head><!-- %s -->", strings.Repeat("x", 2<<20))
+			fmt.Fprintf(w, `<meta name="go-import" content="%s/tool git https://github.com/tj/tool"></head></html>`, r.Host)
+		}))
+		defer vanity.Close()
+
+		r := &resolver.Registry{
+			Client: vanity.Client(),
+		}
+
+		host := strings.TrimPrefix(vanity.URL, "https://")
+		_, err := r.Discover(host + "/tool")
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,537 @@
+package resolver
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tj/go-semver"
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/module"
+	modsemver "golang.org/x/mod/semver"
+
+	"github.com/tj/gobinaries"
+)
+
+// Source is the interface used to fetch repository tags and commits.
+type Source interface {
+	// Versions returns the tags of a repository.
+	Versions(owner, repo string) ([]string, error)
+
+	// Commit returns the commit of a branch, or a full or abbreviated commit SHA.
+	Commit(owner, repo, ref string) (Commit, error)
+
+	// GoMod returns the go.mod file in the directory dir of a repository at the
+	// given tag or commit, or nil when the directory has no go.mod file.
+	GoMod(owner, repo, ref, dir string) ([]byte, error)
+}
+
+// Commit is a repository commit.
+type Commit struct {
+	// SHA is the full commit SHA.
+	SHA string
+
+	// Time is the commit time.
+	Time time.Time
+}
+
+// version is a tag parsed as a semver version.
+type version struct {
+	// tag is the original tag such as "v1.2.3+incompatible".
+	tag string
+
+	// canonical is the canonical semver such as "v1.2.3".
+	canonical string
+
+	// core is the major, minor and patch version used for range matching.
+	core semver.Version
+}
+
+// prerelease returns true if the version is a prerelease such as "v1.2.3-rc.1".
+func (v version) prerelease() bool {
+	return modsemver.Prerelease(v.canonical) != ""
+}
+
+// parseVersion returns the tag parsed as a semver version,
+// the leading "v" is optional.
+func parseVersion(tag string) (version, bool) {
+	canonical := "v" + strings.TrimPrefix(tag, "v")
+	if !modsemver.IsValid(canonical) {
+		return version{}, false
+	}
+
+	// reject shorthands such as "v1.2"
+	core := strings.TrimSuffix(canonical, modsemver.Build(canonical))
+	core = strings.TrimSuffix(core, modsemver.Prerelease(canonical))
+	v, err := semver.Parse(core)
+	if err != nil {
+		return version{}, false
+	}
+
+	return version{
+		tag:       tag,
+		canonical: canonical,
+		core:      v,
+	}, true
+}
+
+// parseTag returns the tag parsed as a semver version. Tags without
+// a leading "v" such as "1.2.3" are ignored, as they are by `go`.
+func parseTag(tag string) (version, bool) {
+	if !strings.HasPrefix(tag, "v") {
+		return version{}, false
+	}
+	return parseVersion(tag)
+}
+
+// parseVersions returns the semver tags sorted by descending precedence, ignoring malformed tags.
+func parseVersions(tags []string) (versions []version) {
+	for _, t := range tags {
+		if v, ok := parseTag(t); ok {
+			versions = append(versions, v)
+		}
+	}
+
+	sort.SliceStable(versions, func(i, j int) bool {
+		return modsemver.Compare(versions[i].canonical, versions[j].canonical) > 0
+	})
+
+	return
+}
+
+// resolveSource returns the tag matching the requested version, or a pseudo-version
+// when a branch or commit is requested, for the module in the directory dir.
+func resolveSource(s Source, owner, repo, dir, requested string) (string, error) {
+	tags, err := moduleVersions(s, owner, repo, dir)
+
+	// semver
+	if isRange(requested) {
+		if err != nil {
+			return "", err
+		}
+
+		// retracted versions are only resolved when explicitly requested
+		if _, ok := parseVersion(requested); !ok {
+			m, err := latestGoMod(s, owner, repo, dir, tags)
+			if err != nil {
+				return "", err
+			}
+
+			tags = m.exclude(tags)
+			if len(tags) == 0 {
+				return "", gobinaries.ErrNoVersionMatch
+			}
+		}
+
+		return resolve(tags, requested)
+	}
+
+	// branch or commit
+	if err != nil && err != gobinaries.ErrNoVersions {
+		return "", err
+	}
+
+	c, err := s.Commit(owner, repo, requested)
+	if err != nil {
+		return "", err
+	}
+
+	return pseudoVersion(tags, c), nil
+}
+
+// deprecationSource returns the deprecation message of the latest version of the module in the directory dir.
+func deprecationSource(s Source, owner, repo, dir string) (string, error) {
+	tags, err := moduleVersions(s, owner, repo, dir)
+	if err != nil {
+		return "", err
+	}
+
+	m, err := latestGoMod(s, owner, repo, dir, tags)
+	if err != nil {
+		return "", err
+	}
+
+	return m.deprecated, nil
+}
+
+// moduleSource returns the module of a package, which is the module in the deepest
+// directory containing the package with path-prefixed tags such as "cmd/tool/v1.2.3",
+// otherwise the module at the root of the repository.
+func moduleSource(s Source, owner, repo, pkg string) (string, error) {
+	root := "github.com/" + owner + "/" + repo
+	rel := strings.Trim(strings.TrimPrefix(pkg, root), "/")
+
+	tags, err := s.Versions(owner, repo)
+	if err != nil && err != gobinaries.ErrNoVersions {
+		return "", err
+	}
+
+	for dir := rel; dir != ""; dir = parentDir(dir) {
+		if len(moduleTags(tags, dir)) > 0 {
+			return root + "/" + dir, nil
+		}
+	}
+
+	return root, nil
+}
+
+// moduleVersions returns the versions of the module in the directory dir.
+func moduleVersions(s Source, owner, repo, dir string) ([]string, error) {
+	tags, err := s.Versions(owner, repo)
+	if err != nil {
+		return nil, err
+	}
+
+	versions := moduleTags(tags, dir)
+	if len(versions) == 0 {
+		return nil, gobinaries.ErrNoVersions
+	}
+
+	return versions, nil
+}
+
+// moduleTags returns the versions of the module in the directory dir, from the tags
+// prefixed with the directory such as "cmd/tool/v1.2.3" when it is not the root. The
+// versions of a major version directory such as "cmd/tool/v2" must match its major version.
+func moduleTags(tags []string, dir string) (versions []string) {
+	prefix, major := splitDir(dir)
+
+	for _, t := range tags {
+		if !strings.HasPrefix(t, prefix) {
+			continue
+		}
+
+		v := strings.TrimPrefix(t, prefix)
+		if strings.Contains(v, "/") {
+			continue
+		}
+
+		p, ok := parseTag(v)
+		if major != "" && (!ok || modsemver.Major(p.canonical) != major) {
+			continue
+		}
+
+		if ok || prefix == "" {
+			versions = append(versions, v)
+		}
+	}
+
+	return
+}
+
+// tagPrefix returns the tag prefix of the module in the directory dir.
+func tagPrefix(dir string) string {
+	prefix, _ := splitDir(dir)
+	return prefix
+}
+
+// splitDir returns the tag prefix and major version of the module in the
+// directory dir, such as "cmd/tool/" and "v2" for "cmd/tool/v2".
+func splitDir(dir string) (prefix, major string) {
+	if dir == "" {
+		return "", ""
+	}
+
+	if _, pathMajor, ok := module.SplitPathVersion("/" + dir); ok && strings.HasPrefix(pathMajor, "/") {
+		major = pathMajor[1:]
+		dir = strings.TrimSuffix(strings.TrimSuffix(dir, major), "/")
+	}
+
+	if dir == "" {
+		return "", major
+	}
+
+	return dir + "/", major
+}
+
+// latestGoMod returns the go.mod file of the latest version of the module in the directory dir.
+func latestGoMod(s Source, owner, repo, dir string, tags []string) (goMod, error) {
+	latest, err := resolve(tags, "latest")
+	if err != nil {
+		return goMod{}, nil
+	}
+
+	prefix, major := splitDir(dir)
+	b, err := s.GoMod(owner, repo, prefix+latest, dir)
+
+	// major version branches without "/vN" directory
+	if err == nil && b == nil && major != "" {
+		b, err = s.GoMod(owner, repo, prefix+latest, strings.TrimSuffix(prefix, "/"))
+	}
+
+	if err != nil {
+		return goMod{}, fmt.Errorf("getting go.mod: %w", err)
+	}
+
+	return parseGoMod(b)
+}
+
+// resolvePackageSource returns the package at the resolved version, where the module
+// path is read from the go.mod file closest to the package in the repository.
+func resolvePackageSource(s Source, owner, repo, dir, mod, pkg, version string) (gobinaries.Package, error) {
+	ref := tagPrefix(dir) + version
+	if m := PseudoVersionRE.FindStringSubmatch(version); m != nil {
+		ref = m[1]
+	}
+
+	root := "github.com/" + owner + "/" + repo
+	rel := strings.Trim(strings.TrimPrefix(pkg, root), "/")
+
+	for d := rel; ; d = parentDir(d) {
+		b, err := s.GoMod(owner, repo, ref, d)
+		if err != nil {
+			return gobinaries.Package{}, fmt.Errorf("getting go.mod: %w", err)
+		}
+
+		m, err := parseGoMod(b)
+		if err != nil {
+			return gobinaries.Package{}, err
+		}
+
+		if m.path != "" {
+			return newPackage(m.path, strings.Trim(strings.TrimPrefix(rel, d), "/"), version), nil
+		}
+
+		if d == "" {
+			break
+		}
+	}
+
+	// legacy module without go.mod file
+	return newPackage(mod, strings.Trim(strings.TrimPrefix(pkg, mod), "/"), version), nil
+}
+
+// newPackage returns the package in the directory rel of a module at the given version.
+func newPackage(mod, rel, version string) gobinaries.Package {
+	_, pathMajor, _ := module.SplitPathVersion(mod)
+	major := strings.TrimLeft(pathMajor, "/.")
+
+	// major version suffix without a "/vN" directory, such as
+	// a v2 module developed on the master branch
+	if major != "" && (rel == major || strings.HasPrefix(rel, major+"/")) {
+		rel = strings.Trim(strings.TrimPrefix(rel, major), "/")
+	}
+
+	// modules without go.mod file, or which did not adopt major version suffixes
+	if pathMajor == "" && modsemver.IsValid(version) && module.CheckPathMajor(version, "") != nil {
+		version += "+incompatible"
+	}
+
+	p := mod
+	if rel != "" {
+		p += "/" + rel
+	}
+
+	return gobinaries.Package{
+		Path:    p,
+		Module:  mod,
+		Version: version,
+	}
+}
+
+// parentDir returns the parent of a slash-separated directory, or an empty string.
+func parentDir(dir string) string {
+	dir = path.Dir(dir)
+	if dir == "." || dir == "/" {
+		return ""
+	}
+	return dir
+}
+
+// goMod is the information of a go.mod file used for resolution.
+type goMod struct {
+	// path is the module path.
+	path string
+
+	// retract is the list of retracted versions.
+	retract []*modfile.Retract
+
+	// deprecated is the deprecation message of the module.
+	deprecated string
+}
+
+// parseGoMod returns the go.mod file information.
+func parseGoMod(b []byte) (goMod, error) {
+	if b == nil {
+		return goMod{}, nil
+	}
+
+	f, err := modfile.ParseLax("go.mod", b, nil)
+	if err != nil {
+		return goMod{}, fmt.Errorf("parsing go.mod: %w", err)
+	}
+
+	m := goMod{
+		retract: f.Retract,
+	}
+
+	if f.Module != nil {
+		m.path = f.Module.Mod.Path
+		m.deprecated = parseDeprecation(f.Module.Syntax)
+	}
+
+	return m, nil
+}
+
+// parseDeprecation returns the "Deprecated:" paragraph of the module comments.
+func parseDeprecation(line *modfile.Line) string {
+	var lines []string
+	for _, c := range append(line.Before, line.Suffix...) {
+		lines = append(lines, strings.TrimSpace(strings.TrimPrefix(c.Token, "//")))
+	}
+
+	for i, l := range lines {
+		if !strings.HasPrefix(l, "Deprecated:") {
+			continue
+		}
+
+		msg := []string{strings.TrimSpace(strings.TrimPrefix(l, "Deprecated:"))}
+		for _, l := range lines[i+1:] {
+			if l == "" {
+				break
+			}
+			msg = append(msg, l)
+		}
+
+		return strings.TrimSpace(strings.Join(msg, " "))
+	}
+
+	return ""
+}
+
+// retracted returns true if the tag is retracted.
+func (m goMod) retracted(tag string) bool {
+	v, ok := parseVersion(tag)
+	if !ok {
+		return false
+	}
+
+	for _, r := range m.retract {
+		if modsemver.Compare(v.canonical, r.Low) >= 0 && modsemver.Compare(v.canonical, r.High) <= 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// exclude returns the tags which are not retracted.
+func (m goMod) exclude(tags []string) (versions []string) {
+	for _, t := range tags {
+		if !m.retracted(t) {
+			versions = append(versions, t)
+		}
+	}
+	return
+}
+
+// isRange returns true if the requested version is "latest", a semver
+// version or range such as "1.x", rather than a branch or commit.
+func isRange(requested string) bool {
+	if requested == "latest" {
+		return true
+	}
+
+	if _, ok := parseVersion(requested); ok {
+		return true
+	}
+
+	parts := strings.Split(strings.TrimPrefix(requested, "v"), ".")
+	if len(parts) > 3 {
+		return false
+	}
+
+	for _, p := range parts {
+		if p == "x" || p == "X" || p == "*" {
+			continue
+		}
+
+		if p == "" || strings.Trim(p, "0123456789") != "" {
+			return false
+		}
+
+		// leading zeros and lengths only seen in abbreviated commit SHAs
+		if (len(p) > 1 && p[0] == '0') || len(p) >= 7 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// PseudoVersionRE matches Go pseudo-versions such as "v0.0.0-20200101120000-abcdef123456",
+// where the first submatch is the abbreviated commit SHA.
+var PseudoVersionRE = regexp.MustCompile(`^v[0-9]+\.(?:0\.0-|\d+\.\d+-(?:[^+]*\.)?0\.)\d{14}-([A-Za-z0-9]+)(?:\+incompatible)?$`)
+
+// pseudoVersion returns a Go pseudo-version for the commit, such as
+// "v0.0.0-20200101120000-abcdef123456", or "v2.0.0-20200101120000-abcdef123456"
+// when the latest release is a v2 or above module.
+func pseudoVersion(tags []string, c Commit) string {
+	major := 0
+	for _, v := range parseVersions(tags) {
+		if !v.prerelease() {
+			major = v.core.Major
+			break
+		}
+	}
+
+	if major < 2 {
+		major = 0
+	}
+
+	sha := c.SHA
+	if len(sha) > 12 {
+		sha = sha[:12]
+	}
+
+	return fmt.Sprintf("v%d.0.0-%s-%s", major, c.Time.UTC().Format("20060102150405"), sha)
+}
+
+// resolve returns the tag matching the requested version. Prereleases
+// are only matched when explicitly requested, or by "latest" when there
+// are no releases.
+func resolve(tags []string, requested string) (string, error) {
+	versions := parseVersions(tags)
+
+	// no versions, it has tags but they're not semver
+	if len(versions) == 0 {
+		return "", gobinaries.ErrNoVersions
+	}
+
+	// latest special-case
+	if requested == "latest" {
+		for _, v := range versions {
+			if !v.prerelease() {
+				return v.tag, nil
+			}
+		}
+		return versions[0].tag, nil
+	}
+
+	// explicit prerelease
+	if r, ok := parseVersion(requested); ok && r.prerelease() {
+		for _, v := range versions {
+			if modsemver.Compare(v.canonical, r.canonical) == 0 {
+				return v.tag, nil
+			}
+		}
+		return "", gobinaries.ErrNoVersionMatch
+	}
+
+	// match requested semver range
+	vr, err := semver.ParseRange(requested)
+	if err != nil {
+		return "", fmt.Errorf("parsing version range: %w", err)
+	}
+
+	for _, v := range versions {
+		if !v.prerelease() && vr.Match(v.core) {
+			return v.tag, nil
+		}
+	}
+
+	return "", gobinaries.ErrNoVersionMatch
+}
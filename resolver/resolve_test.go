@@ -0,0 +1,432 @@
+package resolver
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/tj/assert"
+
+	"github.com/tj/gobinaries"
+)
+
+// Test resolving versions from shuffled tags.
+func TestResolve(t *testing.T) {
+	tags := []string{
+		"v0.9.0",
+		"v1.0.0",
+		"v1.2.0",
+		"v1.10.0",
+		"v1.10.1",
+		"v1.11.0-rc.1",
+		"v2.0.0-beta.1",
+		"1.9.0",
+		"latest",
+		"release-2019",
+		"v1.3",
+	}
+
+	cases := []struct {
+		version  string
+		expected string
+		err      error
+	}{
+		{"latest", "v1.10.1", nil},
+		{"v1.2.0", "v1.2.0", nil},
+		{"1.2.0", "v1.2.0", nil},
+		{"1.9.0", "", gobinaries.ErrNoVersionMatch},
+		{"1.x", "v1.10.1", nil},
+		{"v1", "v1.10.1", nil},
+		{"1.10.x", "v1.10.1", nil},
+		{"1.10", "v1.10.1", nil},
+		{"0.x", "v0.9.0", nil},
+		{"1.11.x", "", gobinaries.ErrNoVersionMatch},
+		{"2.x", "", gobinaries.ErrNoVersionMatch},
+		{"v1.11.0-rc.1", "v1.11.0-rc.1", nil},
+		{"2.0.0-beta.1", "v2.0.0-beta.1", nil},
+		{"v2.0.0-beta.2", "", gobinaries.ErrNoVersionMatch},
+		{"3.x", "", gobinaries.ErrNoVersionMatch},
+	}
+
+	r := rand.New(rand.NewSource(1))
+
+	for _, c := range cases {
+		t.Run(c.version, func(t *testing.T) {
+			for i := 0; i < 10; i++ {
+				shuffled := append([]string(nil), tags...)
+				r.Shuffle(len(shuffled), func(i, j int) {
+					shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+				})
+
+				v, err := resolve(shuffled, c.version)
+				assert.Equal(t, c.err, err, "tags %v", shuffled)
+				assert.Equal(t, c.expected, v, "tags %v", shuffled)
+			}
+		})
+	}
+
+	t.Run("latest with only prereleases", func(t *testing.T) {
+		v, err := resolve([]string{"v1.0.0-rc.1", "v1.0.0-rc.2", "v0.1.0-alpha"}, "latest")
+		assert.NoError(t, err)
+		assert.Equal(t, "v1.0.0-rc.2", v)
+	})
+
+	t.Run("no semver tags", func(t *testing.T) {
+		_, err := resolve([]string{"latest", "v1.2"}, "latest")
+		assert.Equal(t, gobinaries.ErrNoVersions, err)
+	})
+}
+
+// source is a source with the given tags, commits and go.mod files.
+type source struct {
+	tags    []string
+	commits map[string]Commit
+	mods    map[string]string
+}
+
+// Versions implementation.
+func (s *source) Versions(owner, repo string) ([]string, error) {
+	if len(s.tags) == 0 {
+		return nil, gobinaries.ErrNoVersions
+	}
+	return s.tags, nil
+}
+
+// Commit implementation.
+func (s *source) Commit(owner, repo, ref string) (Commit, error) {
+	c, ok := s.commits[ref]
+	if !ok {
+		return Commit{}, gobinaries.ErrNoVersionMatch
+	}
+	return c, nil
+}
+
+// GoMod implementation, where files are keyed by "<ref>" or "<ref>:<dir>".
+func (s *source) GoMod(owner, repo, ref, dir string) ([]byte, error) {
+	key := ref
+	if dir != "" {
+		key += ":" + dir
+	}
+
+	m, ok := s.mods[key]
+	if !ok {
+		return nil, nil
+	}
+	return []byte(m), nil
+}
+
+// Test resolving branches and commits.
+func TestResolveSource(t *testing.T) {
+	commit := Commit{
+		SHA:  "0123456789abcdef0123456789abcdef01234567",
+		Time: time.Date(2020, 4, 9, 12, 30, 15, 0, time.FixedZone("PDT", -7*3600)),
+	}
+
+	commits := map[string]Commit{
+		"master":    commit,
+		"feature/x": commit,
+		"0123456":   commit,
+	}
+
+	cases := []struct {
+		name     string
+		tags     []string
+		version  string
+		expected string
+		err      error
+	}{
+		{"tag", []string{"v1.0.0"}, "1.x", "v1.0.0", nil},
+		{"branch", []string{"v1.0.0"}, "master", "v0.0.0-20200409193015-0123456789ab", nil},
+		{"branch with slash", []string{"v1.0.0"}, "feature/x", "v0.0.0-20200409193015-0123456789ab", nil},
+		{"commit", []string{"v1.0.0"}, "0123456", "v0.0.0-20200409193015-0123456789ab", nil},
+		{"commit without tags", nil, "0123456", "v0.0.0-20200409193015-0123456789ab", nil},
+		{"commit of v2 module", []string{"v1.0.0", "v2.1.0", "v3.0.0-rc.1"}, "master", "v2.0.0-20200409193015-0123456789ab", nil},
+		{"missing branch", []string{"v1.0.0"}, "develop", "", gobinaries.ErrNoVersionMatch},
+		{"range without tags", nil, "1.x", "", gobinaries.ErrNoVersions},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			s := &source{
+				tags:    c.tags,
+				commits: commits,
+			}
+
+			v, err := resolveSource(s, "tj", "triage", "", c.version)
+			assert.Equal(t, c.err, err)
+			assert.Equal(t, c.expected, v)
+		})
+	}
+}
+
+// Test resolving with retracted versions.
+func TestResolveSource_retract(t *testing.T) {
+	s := &source{
+		tags: []string{"v1.0.0", "v1.1.0", "v1.2.0", "v1.3.0"},
+		mods: map[string]string{
+			"v1.3.0": `module github.com/tj/triage
+
+retract (
+	v1.3.0 // Retraction only
+	[v1.1.0, v1.2.0] // Broken
+)
+`,
+		},
+	}
+
+	cases := []struct {
+		version  string
+		expected string
+		err      error
+	}{
+		{"latest", "v1.0.0", nil},
+		{"1.x", "v1.0.0", nil},
+		{"1.2.x", "", gobinaries.ErrNoVersionMatch},
+		{"v1.2.0", "v1.2.0", nil},
+	}
+
+	for _, c := range cases {
+		t.Run(c.version, func(t *testing.T) {
+			v, err := resolveSource(s, "tj", "triage", "", c.version)
+			assert.Equal(t, c.err, err)
+			assert.Equal(t, c.expected, v)
+		})
+	}
+
+	t.Run("all retracted", func(t *testing.T) {
+		s := &source{
+			tags: []string{"v1.0.0"},
+			mods: map[string]string{
+				"v1.0.0": "module github.com/tj/triage\n\nretract v1.0.0\n",
+			},
+		}
+
+		_, err := resolveSource(s, "tj", "triage", "", "latest")
+		assert.Equal(t, gobinaries.ErrNoVersionMatch, err)
+	})
+}
+
+// Test parsing deprecation messages.
+func TestDeprecationSource(t *testing.T) {
+	cases := []struct {
+		name     string
+		mod      string
+		expected string
+	}{
+		{"none", "module github.com/tj/triage\n", ""},
+		{"missing", "", ""},
+		{"before", "// Deprecated: use github.com/tj/triage/v2 instead.\nmodule github.com/tj/triage\n", "use github.com/tj/triage/v2 instead."},
+		{"suffix", "module github.com/tj/triage // Deprecated: unmaintained\n", "unmaintained"},
+		{"paragraph", "// Package triage.\n//\n// Deprecated: this module\n// is unmaintained.\n//\n// Other comment.\nmodule github.com/tj/triage\n", "this module is unmaintained."},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			s := &source{
+				tags: []string{"v1.0.0"},
+			}
+
+			if c.mod != "" {
+				s.mods = map[string]string{"v1.0.0": c.mod}
+			}
+
+			msg, err := deprecationSource(s, "tj", "triage", "")
+			assert.NoError(t, err)
+			assert.Equal(t, c.expected, msg)
+		})
+	}
+}
+
+// Test resolving packages from go.mod files.
+func TestResolvePackageSource(t *testing.T) {
+	s := &source{
+		mods: map[string]string{
+			"v0.1.0":          "module github.com/tj/triage\n",
+			"v1.2.0":          "module github.com/tj/triage\n",
+			"v2.1.0":          "module github.com/tj/triage/v2\n",
+			"v3.0.0:v3":       "module github.com/tj/triage/v3\n",
+			"v3.0.0":          "module github.com/tj/triage\n",
+			"v1.5.0:tools":    "module github.com/tj/triage/tools\n",
+			"v1.5.0":          "module github.com/tj/triage\n",
+			"0123456789ab":    "module github.com/tj/triage/v2\n",
+			"v1.6.0:tools/v2": "module github.com/tj/triage/tools/v2\n",
+			"v4.0.0":          "module tj.dev/triage/v4\n",
+		},
+	}
+
+	cases := []struct {
+		name     string
+		pkg      string
+		version  string
+		expected gobinaries.Package
+	}{
+		{"v0", "github.com/tj/triage/cmd/triage", "v0.1.0", gobinaries.Package{
+			Path:    "github.com/tj/triage/cmd/triage",
+			Module:  "github.com/tj/triage",
+			Version: "v0.1.0",
+		}},
+		{"v1", "github.com/tj/triage", "v1.2.0", gobinaries.Package{
+			Path:    "github.com/tj/triage",
+			Module:  "github.com/tj/triage",
+			Version: "v1.2.0",
+		}},
+		{"v2", "github.com/tj/triage/cmd/triage", "v2.1.0", gobinaries.Package{
+			Path:    "github.com/tj/triage/v2/cmd/triage",
+			Module:  "github.com/tj/triage/v2",
+			Version: "v2.1.0",
+		}},
+		{"v2 requested with suffix", "github.com/tj/triage/v2/cmd/triage", "v2.1.0", gobinaries.Package{
+			Path:    "github.com/tj/triage/v2/cmd/triage",
+			Module:  "github.com/tj/triage/v2",
+			Version: "v2.1.0",
+		}},
+		{"v3 directory", "github.com/tj/triage/v3/cmd/triage", "v3.0.0", gobinaries.Package{
+			Path:    "github.com/tj/triage/v3/cmd/triage",
+			Module:  "github.com/tj/triage/v3",
+			Version: "v3.0.0",
+		}},
+		{"incompatible", "github.com/tj/triage/cmd/triage", "v2.0.0", gobinaries.Package{
+			Path:    "github.com/tj/triage/cmd/triage",
+			Module:  "github.com/tj/triage",
+			Version: "v2.0.0+incompatible",
+		}},
+		{"incompatible without major version suffix", "github.com/tj/triage/cmd/triage", "v3.0.0", gobinaries.Package{
+			Path:    "github.com/tj/triage/cmd/triage",
+			Module:  "github.com/tj/triage",
+			Version: "v3.0.0+incompatible",
+		}},
+		{"nested", "github.com/tj/triage/tools/cmd/lint", "v1.5.0", gobinaries.Package{
+			Path:    "github.com/tj/triage/tools/cmd/lint",
+			Module:  "github.com/tj/triage/tools",
+			Version: "v1.5.0",
+		}},
+		{"nested v2", "github.com/tj/triage/tools/v2/cmd/lint", "v1.6.0", gobinaries.Package{
+			Path:    "github.com/tj/triage/tools/v2/cmd/lint",
+			Module:  "github.com/tj/triage/tools/v2",
+			Version: "v1.6.0",
+		}},
+		{"pseudo-version", "github.com/tj/triage/cmd/triage", "v2.0.0-20200409193015-0123456789ab", gobinaries.Package{
+			Path:    "github.com/tj/triage/v2/cmd/triage",
+			Module:  "github.com/tj/triage/v2",
+			Version: "v2.0.0-20200409193015-0123456789ab",
+		}},
+		{"vanity", "github.com/tj/triage/cmd/triage", "v4.0.0", gobinaries.Package{
+			Path:    "tj.dev/triage/v4/cmd/triage",
+			Module:  "tj.dev/triage/v4",
+			Version: "v4.0.0",
+		}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			p, err := resolvePackageSource(s, "tj", "triage", "", "github.com/tj/triage", c.pkg, c.version)
+			assert.NoError(t, err)
+			assert.Equal(t, c.expected, p)
+		})
+	}
+}
+
+// Test resolving modules tagged with path prefixes.
+func TestResolveSource_prefix(t *testing.T) {
+	s := &source{
+		tags: []string{
+			"v1.0.0",
+			"v1.1.0",
+			"cmd/tool/v0.1.0",
+			"cmd/tool/v0.2.0",
+			"cmd/tool/v2.0.0",
+			"cmd/tool/v3.0.0-rc.1",
+			"cmd/other/v1.5.0",
+			"cmd/tool/latest",
+		},
+		commits: map[string]Commit{
+			"master": {
+				SHA:  "0123456789abcdef0123456789abcdef01234567",
+				Time: time.Date(2020, 4, 9, 19, 30, 15, 0, time.UTC),
+			},
+		},
+		mods: map[string]string{
+			"cmd/tool/v2.0.0:cmd/tool": "module github.com/tj/mono/cmd/tool/v2\n\nretract v2.0.0\n",
+		},
+	}
+
+	cases := []struct {
+		dir      string
+		version  string
+		expected string
+		err      error
+	}{
+		{"", "latest", "v1.1.0", nil},
+		{"", "1.0.x", "v1.0.0", nil},
+		{"cmd/tool", "latest", "v0.2.0", nil},
+		{"cmd/tool", "0.1.x", "v0.1.0", nil},
+		{"cmd/tool", "v2.0.0", "v2.0.0", nil},
+		{"cmd/tool", "v3.0.0-rc.1", "v3.0.0-rc.1", nil},
+		{"cmd/tool", "master", "v2.0.0-20200409193015-0123456789ab", nil},
+		{"cmd/tool/v2", "v2.0.0", "v2.0.0", nil},
+		{"cmd/tool/v2", "latest", "", gobinaries.ErrNoVersionMatch},
+		{"cmd/other", "latest", "v1.5.0", nil},
+		{"cmd/missing", "latest", "", gobinaries.ErrNoVersions},
+	}
+
+	for _, c := range cases {
+		t.Run(c.dir+"@"+c.version, func(t *testing.T) {
+			v, err := resolveSource(s, "tj", "mono", c.dir, c.version)
+			assert.Equal(t, c.err, err)
+			assert.Equal(t, c.expected, v)
+		})
+	}
+
+	t.Run("package", func(t *testing.T) {
+		p, err := resolvePackageSource(s, "tj", "mono", "cmd/tool", "github.com/tj/mono/cmd/tool", "github.com/tj/mono/cmd/tool", "v2.0.0")
+		assert.NoError(t, err)
+		assert.Equal(t, gobinaries.Package{
+			Path:    "github.com/tj/mono/cmd/tool/v2",
+			Module:  "github.com/tj/mono/cmd/tool/v2",
+			Version: "v2.0.0",
+		}, p)
+	})
+}
+
+// Test resolving the module of a package from path-prefixed tags.
+func TestModuleSource(t *testing.T) {
+	s := &source{
+		tags: []string{
+			"v1.0.0",
+			"cmd/tool/v1.2.3",
+			"tools/v2.0.0",
+		},
+	}
+
+	cases := []struct {
+		pkg      string
+		expected string
+	}{
+		{"github.com/tj/mono", "github.com/tj/mono"},
+		{"github.com/tj/mono/cmd/other", "github.com/tj/mono"},
+		{"github.com/tj/mono/cmd/tool", "github.com/tj/mono/cmd/tool"},
+		{"github.com/tj/mono/cmd/tool/internal/cli", "github.com/tj/mono/cmd/tool"},
+		{"github.com/tj/mono/tools/v2/cmd/lint", "github.com/tj/mono/tools/v2"},
+		{"github.com/tj/mono/v2/cmd/mono", "github.com/tj/mono"},
+		{"github.com/tj/mono/v1/cmd/mono", "github.com/tj/mono"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.pkg, func(t *testing.T) {
+			mod, err := moduleSource(s, "tj", "mono", c.pkg)
+			assert.NoError(t, err)
+			assert.Equal(t, c.expected, mod)
+		})
+	}
+}
+
+// Test detecting semver ranges.
+func TestIsRange(t *testing.T) {
+	for _, s := range []string{"latest", "1", "v1", "1.x", "1.2", "1.2.x", "v1.2.3", "1.2.3-rc.1", "*"} {
+		assert.True(t, isRange(s), s)
+	}
+
+	for _, s := range []string{"master", "develop", "feature/x", "0123456", "abcdef1", "v1.2.3.4", "1.2.foo"} {
+		assert.False(t, isRange(s), s)
+	}
+}
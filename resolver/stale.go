@@ -0,0 +1,149 @@
+package resolver
+
+import (
+	"sync"
+	"time"
+
+	"github.com/tj/gobinaries"
+)
+
+// staleCache is a cache of results where stale results are served while they
+// are refreshed in the background, and ErrNoVersions is cached briefly. Results
+// which can no longer be served are removed periodically, so that the cache
+// does not grow without bound.
+type staleCache struct {
+	once        sync.Once
+	ttl         time.Duration
+	staleTTL    time.Duration
+	negativeTTL time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*cacheEntry
+	swept   time.Time
+}
+
+// cacheEntry is a cached result.
+type cacheEntry struct {
+	value      interface{}
+	err        error
+	created    time.Time
+	refreshing bool
+}
+
+// init sets the durations of the cache, zero durations default to
+// 5 minutes fresh, 24 hours stale, and 1 minute for negative results.
+func (c *staleCache) init(ttl, staleTTL, negativeTTL time.Duration) {
+	c.once.Do(func() {
+		c.ttl = durationDefault(ttl, 5*time.Minute)
+		c.staleTTL = durationDefault(staleTTL, 24*time.Hour)
+		c.negativeTTL = durationDefault(negativeTTL, time.Minute)
+	})
+}
+
+// len returns the number of cached results.
+func (c *staleCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+// get returns the result of the key, from the cache when possible.
+func (c *staleCache) get(key string, fetch func() (interface{}, error)) (interface{}, error) {
+	c.mu.Lock()
+	if c.entries == nil {
+		c.entries = make(map[string]*cacheEntry)
+		c.swept = time.Now()
+	}
+
+	if time.Since(c.swept) >= c.ttl {
+		c.sweep()
+	}
+
+	e, ok := c.entries[key]
+	if ok {
+		age := time.Since(e.created)
+
+		// negative result
+		if e.err != nil && age < c.negativeTTL {
+			c.mu.Unlock()
+			return nil, e.err
+		}
+
+		// fresh
+		if e.err == nil && age < c.ttl {
+			c.mu.Unlock()
+			return e.value, nil
+		}
+
+		// stale, refresh in the background
+		if e.err == nil && age < c.ttl+c.staleTTL {
+			if !e.refreshing {
+				e.refreshing = true
+				go c.refresh(key, fetch)
+			}
+			c.mu.Unlock()
+			return e.value, nil
+		}
+	}
+	c.mu.Unlock()
+
+	return c.fetch(key, fetch)
+}
+
+// refresh an entry, keeping the stale value on error.
+func (c *staleCache) refresh(key string, fetch func() (interface{}, error)) {
+	_, err := c.fetch(key, fetch)
+	if err == nil {
+		return
+	}
+
+	c.mu.Lock()
+	if e, ok := c.entries[key]; ok {
+		e.refreshing = false
+	}
+	c.mu.Unlock()
+}
+
+// fetch the result of a key, caching it.
+func (c *staleCache) fetch(key string, fetch func() (interface{}, error)) (interface{}, error) {
+	v, err := fetch()
+
+	// only cache successful and negative results
+	if err != nil && err != gobinaries.ErrNoVersions {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	c.entries[key] = &cacheEntry{
+		value:   v,
+		err:     err,
+		created: time.Now(),
+	}
+	c.mu.Unlock()
+
+	return v, err
+}
+
+// sweep removes the entries which can no longer be served, c.mu must be held.
+func (c *staleCache) sweep() {
+	for key, e := range c.entries {
+		age := time.Since(e.created)
+		expired := age >= c.ttl+c.staleTTL
+		if e.err != nil {
+			expired = age >= c.negativeTTL
+		}
+
+		if expired && !e.refreshing {
+			delete(c.entries, key)
+		}
+	}
+	c.swept = time.Now()
+}
+
+// durationDefault returns d, or value when d is not positive.
+func durationDefault(d, value time.Duration) time.Duration {
+	if d > 0 {
+		return d
+	}
+	return value
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"crypto/sha256"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/tj/gobinaries"
+)
+
+// artifact is a build spooled to disk, so that binaries are never
+// held in memory while being stored or served. The file is removed
+// once every request sharing the build has released it.
+type artifact struct {
+	path string
+	size int64
+	sum  []byte
+
+	// sig is the signature of the checksum, when signed by the build.
+	sig []byte
+
+	// build is the details of the build, when reported by the builder.
+	build *gobinaries.Build
+
+	// built is the time the build finished, and duration its duration in milliseconds.
+	built    time.Time
+	duration int
+
+	mu   sync.Mutex
+	refs int
+}
+
+// newArtifact returns a new artifact spooled to a temporary file by write,
+// and its SHA-256 checksum.
+func newArtifact(write func(f *os.File) error) (*artifact, error) {
+	f, err := ioutil.TempFile("", "gobinaries-artifact")
+	if err != nil {
+		return nil, err
+	}
+
+	err = write(f)
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+
+	_, err = f.Seek(0, io.SeekStart)
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+
+	err = f.Close()
+	if err != nil {
+		os.Remove(f.Name())
+		return nil, err
+	}
+
+	return &artifact{
+		path: f.Name(),
+		size: size,
+		sum:  h.Sum(nil),
+		refs: 1,
+	}, nil
+}
+
+// Open returns a new reader for the artifact.
+func (a *artifact) Open() (*os.File, error) {
+	return os.Open(a.path)
+}
+
+// Retain adds n references to the artifact.
+func (a *artifact) Retain(n int) {
+	a.mu.Lock()
+	a.refs += n
+	a.mu.Unlock()
+}
+
+// Release removes a reference to the artifact, removing the file when none are left.
+func (a *artifact) Release() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.refs--
+	if a.refs > 0 {
+		return nil
+	}
+	return os.Remove(a.path)
+}
@@ -0,0 +1,56 @@
+package server
+
+import (
+	"sync"
+
+	"github.com/tj/gobinaries"
+)
+
+// call is an in-flight or completed build.
+type call struct {
+	done chan struct{}
+	dups int
+	art  *artifact
+	err  error
+}
+
+// flight deduplicates concurrent builds of the same binary.
+type flight struct {
+	mu    sync.Mutex
+	calls map[gobinaries.Binary]*call
+}
+
+// Do invokes fn for the given binary, making sure that only one
+// invocation is in-flight at a time. Concurrent callers with the
+// same binary wait for the original to complete and receive the
+// same artifact, in which case shared is true. Every caller
+// must release the artifact returned.
+func (f *flight) Do(bin gobinaries.Binary, fn func() (*artifact, error)) (art *artifact, shared bool, err error) {
+	f.mu.Lock()
+	if f.calls == nil {
+		f.calls = make(map[gobinaries.Binary]*call)
+	}
+
+	if c, ok := f.calls[bin]; ok {
+		c.dups++
+		f.mu.Unlock()
+		<-c.done
+		return c.art, true, c.err
+	}
+
+	c := &call{done: make(chan struct{})}
+	f.calls[bin] = c
+	f.mu.Unlock()
+
+	c.art, c.err = fn()
+
+	f.mu.Lock()
+	delete(f.calls, bin)
+	if c.art != nil {
+		c.art.Retain(c.dups)
+	}
+	f.mu.Unlock()
+	close(c.done)
+
+	return c.art, false, c.err
+}
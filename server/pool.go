@@ -0,0 +1,61 @@
+package server
+
+import (
+	"errors"
+	"sync"
+)
+
+// errQueueFull is returned when no build slot is available and the queue is full.
+var errQueueFull = errors.New("build queue full")
+
+// pool bounds the number of concurrent builds, queueing
+// up to a fixed number of builds waiting for a slot.
+type pool struct {
+	once   sync.Once
+	slots  chan struct{}
+	size   int
+	mu     sync.Mutex
+	queued int
+}
+
+// init initializes the pool with the given concurrency and queue size.
+func (p *pool) init(concurrency, size int) {
+	p.once.Do(func() {
+		p.slots = make(chan struct{}, concurrency)
+		p.size = size
+	})
+}
+
+// Acquire a build slot, waiting in the queue when all slots are in use. The
+// queue depth at the time of the call is returned, or errQueueFull when
+// the queue has no room left.
+func (p *pool) Acquire() (depth int, err error) {
+	select {
+	case p.slots <- struct{}{}:
+		return 0, nil
+	default:
+	}
+
+	p.mu.Lock()
+	if p.queued >= p.size {
+		depth = p.queued
+		p.mu.Unlock()
+		return depth, errQueueFull
+	}
+	p.queued++
+	depth = p.queued
+	p.mu.Unlock()
+
+	p.slots <- struct{}{}
+
+	p.mu.Lock()
+	p.queued--
+	p.mu.Unlock()
+
+	return depth, nil
+}
+
+// Release a build slot.
+func (p *pool) Release() {
+	<-p.slots
+}
@@ -0,0 +1,137 @@
+package server
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/tj/go/http/response"
+	"golang.org/x/mod/module"
+
+	"github.com/tj/gobinaries"
+)
+
+// access is the result of checking a request's access to a package.
+type access int
+
+// Access results.
+const (
+	// accessGranted is a public package, or a private package
+	// requested with a valid API token.
+	accessGranted access = iota
+
+	// accessUnauthorized is a private package requested without an API token.
+	accessUnauthorized
+
+	// accessForbidden is a private package requested with an invalid API token,
+	// or any private package when private modules are not enabled.
+	accessForbidden
+)
+
+// isPrivate returns true if the package matches the private module patterns.
+// Paths are matched case-insensitively, as hosts such as GitHub serve the same
+// repository for any case, so that "github.com/ACME/tool" is private as well.
+func (s *Server) isPrivate(pkg string) bool {
+	return s.Private != "" && module.MatchPrefixPatterns(strings.ToLower(s.Private), strings.ToLower(pkg))
+}
+
+// isPrivateBinary returns true if the binary's package or module is private.
+func (s *Server) isPrivateBinary(bin gobinaries.Binary) bool {
+	return s.isPrivate(bin.Path) || s.isPrivate(bin.Module)
+}
+
+// access returns the request's access to the packages or modules, which is the
+// most restrictive access of them. Private packages require a valid API token,
+// sent as "Authorization: Bearer <token>", and private storage.
+func (s *Server) access(r *http.Request, paths ...string) access {
+	private := false
+	for _, path := range paths {
+		if s.isPrivate(path) {
+			private = true
+		}
+	}
+
+	if !private {
+		return accessGranted
+	}
+
+	token := bearerToken(r)
+	if token == "" {
+		return accessUnauthorized
+	}
+
+	if s.PrivateStorage == nil || !s.validToken(token) {
+		return accessForbidden
+	}
+
+	return accessGranted
+}
+
+// checkScriptAccess renders an error script and returns false when
+// the request has no access to the packages or modules.
+func (s *Server) checkScriptAccess(w http.ResponseWriter, r *http.Request, ext string, paths ...string) bool {
+	switch s.access(r, paths...) {
+	case accessUnauthorized:
+		s.render(w, "error"+ext, "Package is private, set GOBINARIES_TOKEN and send it in the Authorization header field")
+		return false
+	case accessForbidden:
+		s.render(w, "error"+ext, "Package is private, and the token provided does not grant access")
+		return false
+	default:
+		return true
+	}
+}
+
+// checkAccess responds with an error and returns false when
+// the request has no access to the packages or modules.
+func (s *Server) checkAccess(w http.ResponseWriter, r *http.Request, paths ...string) bool {
+	switch s.access(r, paths...) {
+	case accessUnauthorized:
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		response.Unauthorized(w, "API token required")
+		return false
+	case accessForbidden:
+		response.Forbidden(w)
+		return false
+	default:
+		return true
+	}
+}
+
+// validToken returns true if the token is one of the configured API tokens.
+func (s *Server) validToken(token string) bool {
+	valid := false
+	for _, t := range s.Tokens {
+		if t != "" && subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			valid = true
+		}
+	}
+	return valid
+}
+
+// storage returns the storage of the binary, where private
+// binaries are never stored alongside public binaries.
+func (s *Server) storage(bin gobinaries.Binary) gobinaries.Storage {
+	if s.isPrivateBinary(bin) {
+		return s.PrivateStorage
+	}
+	return s.Storage
+}
+
+// cacheControl sets the Cache-Control header field of a binary's response,
+// preventing shared caches from storing the responses of private binaries.
+func (s *Server) cacheControl(w http.ResponseWriter, bin gobinaries.Binary, value string) {
+	if s.isPrivateBinary(bin) {
+		value = "private, " + value
+	}
+	w.Header().Set("Cache-Control", value)
+}
+
+// bearerToken returns the token of the Authorization header field, or an empty string.
+func bearerToken(r *http.Request) string {
+	v := r.Header.Get("Authorization")
+	if len(v) < 7 || !strings.EqualFold(v[:7], "bearer ") {
+		return ""
+	}
+	return strings.TrimSpace(v[7:])
+}
@@ -0,0 +1,36 @@
+package server
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/tj/assert"
+)
+
+func TestBearerToken(t *testing.T) {
+	for _, c := range []struct {
+		header string
+		token  string
+	}{
+		{"", ""},
+		{"Bearer", ""},
+		{"Basic dXNlcjpwYXNz", ""},
+		{"Bearer secret", "secret"},
+		{"bearer secret ", "secret"},
+	} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Authorization", c.header)
+		assert.Equal(t, c.token, bearerToken(r), c.header)
+	}
+}
+
+func TestServer_isPrivate(t *testing.T) {
+	s := &Server{Private: "github.com/acme,*.corp.example.com"}
+	assert.True(t, s.isPrivate("github.com/acme/tool"))
+	assert.True(t, s.isPrivate("github.com/ACME/tool"))
+	assert.True(t, s.isPrivate("git.corp.example.com/tool/cmd/tool"))
+	assert.True(t, s.isPrivate("Git.Corp.Example.com/tool"))
+	assert.False(t, s.isPrivate("github.com/acmeco/tool"))
+	assert.False(t, s.isPrivate("github.com/tj/triage"))
+	assert.False(t, (&Server{}).isPrivate("github.com/acme/tool"))
+}
@@ -0,0 +1,154 @@
+package server
+
+import (
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/tj/gobinaries"
+)
+
+// statement is an in-toto statement, with a SLSA provenance predicate
+// describing how a binary was built, see https://slsa.dev/provenance/v0.2.
+type statement struct {
+	Type          string     `json:"_type"`
+	Subject       []subject  `json:"subject"`
+	PredicateType string     `json:"predicateType"`
+	Predicate     provenance `json:"predicate"`
+}
+
+// subject is an artifact of the statement.
+type subject struct {
+	Name   string            `json:"name"`
+	Digest map[string]string `json:"digest"`
+}
+
+// provenance is a SLSA provenance predicate.
+type provenance struct {
+	Builder     provenanceBuilder    `json:"builder"`
+	BuildType   string               `json:"buildType"`
+	Invocation  provenanceInvocation `json:"invocation"`
+	BuildConfig provenanceConfig     `json:"buildConfig"`
+	Metadata    provenanceMetadata   `json:"metadata"`
+	Materials   []provenanceMaterial `json:"materials"`
+}
+
+// provenanceBuilder identifies the server which built the binary.
+type provenanceBuilder struct {
+	ID string `json:"id"`
+}
+
+// provenanceInvocation is the binary requested.
+type provenanceInvocation struct {
+	Parameters gobinaries.Binary `json:"parameters"`
+}
+
+// provenanceConfig is the configuration of the build.
+type provenanceConfig struct {
+	Toolchain string   `json:"toolchain"`
+	Flags     []string `json:"flags"`
+	Ldflags   string   `json:"ldflags"`
+	Env       []string `json:"env"`
+}
+
+// provenanceMetadata is the metadata of the build.
+type provenanceMetadata struct {
+	BuildStartedOn  time.Time              `json:"buildStartedOn"`
+	BuildFinishedOn time.Time              `json:"buildFinishedOn"`
+	Reproducible    bool                   `json:"reproducible"`
+	Completeness    provenanceCompleteness `json:"completeness"`
+}
+
+// provenanceCompleteness describes which fields of the provenance are complete.
+type provenanceCompleteness struct {
+	Parameters  bool `json:"parameters"`
+	Environment bool `json:"environment"`
+	Materials   bool `json:"materials"`
+}
+
+// provenanceMaterial is a module used by the build.
+type provenanceMaterial struct {
+	URI    string            `json:"uri"`
+	Digest map[string]string `json:"digest,omitempty"`
+}
+
+// newStatement returns the provenance statement of a binary built by the server.
+func (s *Server) newStatement(bin gobinaries.Binary, build gobinaries.Build, sum []byte) statement {
+	return statement{
+		Type: "https://in-toto.io/Statement/v0.1",
+		Subject: []subject{
+			{
+				Name:   filename(bin),
+				Digest: map[string]string{"sha256": hex.EncodeToString(sum)},
+			},
+		},
+		PredicateType: "https://slsa.dev/provenance/v0.2",
+		Predicate: provenance{
+			Builder: provenanceBuilder{
+				ID: s.URL,
+			},
+			BuildType: "https://github.com/tj/gobinaries/build@v1",
+			Invocation: provenanceInvocation{
+				Parameters: bin,
+			},
+			BuildConfig: provenanceConfig{
+				Toolchain: build.Toolchain,
+				Flags:     build.Flags,
+				Ldflags:   build.Ldflags,
+				Env:       build.Env,
+			},
+			Metadata: provenanceMetadata{
+				BuildStartedOn:  build.Started.UTC(),
+				BuildFinishedOn: build.Finished.UTC(),
+				Reproducible:    build.Reproducible,
+				Completeness: provenanceCompleteness{
+					Parameters:  true,
+					Environment: build.Reproducible,
+					Materials:   len(build.GoSum) > 0,
+				},
+			},
+			Materials: newMaterials(bin, build),
+		},
+	}
+}
+
+// newMaterials returns the module of the binary, followed by
+// the other modules used by the build listed in its go.sum file.
+func newMaterials(bin gobinaries.Binary, build gobinaries.Build) []provenanceMaterial {
+	materials := []provenanceMaterial{
+		{
+			URI:    "pkg:golang/" + bin.Module + "@" + bin.Version,
+			Digest: goSumDigest(build.Sum),
+		},
+	}
+
+	for _, line := range build.GoSum {
+		f := strings.Fields(line)
+
+		// go.mod hashes of modules which may not be used
+		if len(f) != 3 || strings.HasSuffix(f[1], "/go.mod") {
+			continue
+		}
+
+		if f[0] == bin.Module && f[1] == bin.Version {
+			continue
+		}
+
+		materials = append(materials, provenanceMaterial{
+			URI:    "pkg:golang/" + f[0] + "@" + f[1],
+			Digest: goSumDigest(f[2]),
+		})
+	}
+
+	return materials
+}
+
+// goSumDigest returns the digest of a go.sum hash such as "h1:...",
+// keyed by its algorithm, or nil when the hash is unknown.
+func goSumDigest(hash string) map[string]string {
+	parts := strings.SplitN(hash, ":", 2)
+	if len(parts) != 2 {
+		return nil
+	}
+	return map[string]string{parts[0]: parts[1]}
+}
@@ -4,11 +4,23 @@ package server
 import (
 	"bytes"
 	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"mime"
 	"net/http"
 	"os"
 	"path/filepath"
+	"runtime"
+	"strconv"
 	"strings"
 	"sync"
 	"text/template"
@@ -17,9 +29,9 @@ import (
 	"github.com/apex/log"
 	"github.com/tj/go/http/request"
 	"github.com/tj/go/http/response"
+	"golang.org/x/mod/semver"
 
 	"github.com/tj/gobinaries"
-	"github.com/tj/gobinaries/build"
 )
 
 // Server is the binary server.
@@ -30,20 +42,61 @@ type Server struct {
 	// Static file directory.
 	Static string
 
+	// Templates is the script template directory, defaulting to "templates".
+	Templates string
+
 	// Store is the object storage.
 	Storage gobinaries.Storage
 
+	// Private is an optional comma-separated list of glob patterns of private
+	// module path prefixes, in the syntax of GOPRIVATE, such as "github.com/acme".
+	// Private packages are only served to requests with a valid API token.
+	Private string
+
+	// Tokens are the API tokens granting access to private packages.
+	Tokens []string
+
+	// PrivateStorage is the object storage of private binaries, which is required
+	// in order to serve private packages, and should use a separate prefix.
+	PrivateStorage gobinaries.Storage
+
 	// Resolver is the version resolver.
 	Resolver gobinaries.Resolver
 
+	// Builder is the binary builder.
+	Builder gobinaries.Builder
+
+	// SigningKey is an optional key used to sign the SHA-256 checksum of each binary,
+	// where the message signed binds the checksum to the binary, see signatureMessage.
+	SigningKey ed25519.PrivateKey
+
+	// Concurrency is the maximum number of concurrent builds, defaulting to the number of CPUs.
+	Concurrency int
+
+	// QueueSize is the maximum number of builds waiting for a free slot, defaulting to 100.
+	QueueSize int
+
+	// RetryAfter is the delay suggested to clients when the build queue is full, defaulting to 30 seconds.
+	RetryAfter time.Duration
+
+	// StoreConcurrency is the maximum number of binaries stored in the background, defaulting
+	// to 10. Binaries are stored before they are served when every slot is in use.
+	StoreConcurrency int
+
 	once      sync.Once
 	templates *template.Template
+	builds    flight
+	storing   storing
+	pool      pool
 }
 
 // ServeHTTP implementation.
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	s.once.Do(func() {
-		s.templates = template.Must(template.ParseGlob("templates/*"))
+		s.templates = template.Must(template.New("").Funcs(template.FuncMap{
+			"escapeMessage":    escapeMessage,
+			"escapePowerShell": escapePowerShell,
+		}).ParseGlob(filepath.Join(s.templateDir(), "*")))
 	})
 
 	path := r.URL.Path
@@ -72,6 +125,33 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// serve binary checksum
+	if strings.HasPrefix(path, "/checksum/") {
+		r.URL.Path = strings.TrimPrefix(r.URL.Path, "/checksum/")
+		s.getChecksum(w, r)
+		return
+	}
+
+	// serve binary checksum signature
+	if strings.HasPrefix(path, "/signature/") {
+		r.URL.Path = strings.TrimPrefix(r.URL.Path, "/signature/")
+		s.getSignature(w, r)
+		return
+	}
+
+	// serve binary metadata
+	if strings.HasPrefix(path, "/info/") {
+		r.URL.Path = strings.TrimPrefix(r.URL.Path, "/info/")
+		s.getInfo(w, r)
+		return
+	}
+
+	// serve the public key of signatures
+	if path == "/signing-key.pem" {
+		s.getSigningKey(w, r)
+		return
+	}
+
 	// check if we have a static file,
 	// serve it before we try to fetch
 	// information from Github
@@ -91,11 +171,25 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 // version, responding with an installation script to request
 // the binary built for the user's machine.
 //
+// A PowerShell script is served instead when the path has the ".ps1"
+// suffix, or when requested with the Accept header field.
+//
+// Private packages require an API token, and the script sends
+// the token of the GOBINARIES_TOKEN variable when downloading.
+//
 // Known errors respond with shell scripts as well,
 // in order to provide nicer in-shell error messages,
 // otherwise the curl request will silently fail.
 func (s *Server) getScript(w http.ResponseWriter, r *http.Request) {
 	path := strings.TrimPrefix(r.URL.Path, "/")
+	w.Header().Set("Vary", "Accept")
+
+	ext := ".sh"
+	path, ps := powerShell(path, r.Header.Get("Accept"))
+	if ps {
+		ext = ".ps1"
+	}
+
 	pkg, mod, version, bin := parsePackage(path)
 
 	if pkg == "" {
@@ -103,73 +197,97 @@ func (s *Server) getScript(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	parts := strings.Split(pkg, "/")
-
-	if len(parts) < 3 {
-		response.BadRequest(w)
+	// private packages are checked before resolving,
+	// so that their existence is not disclosed
+	if !s.checkScriptAccess(w, r, ext, pkg) {
 		return
 	}
 
-	owner := parts[1]
-	repo := parts[2]
-
 	logs := log.WithFields(log.Fields{
 		"ip":      r.Header.Get("CF-Connecting-IP"),
 		"package": pkg,
-		"module":  mod,
-		"owner":   owner,
-		"repo":    repo,
 		"binary":  bin,
 		"version": version,
+		"script":  ext,
 	})
 
+	mod, err := s.module(pkg, mod)
+	if err != nil {
+		logs.WithError(err).Warn("error finding module")
+		s.render(w, "error"+ext, "Failed to find the module of the requested package")
+		return
+	}
+
+	// the module may be private when the package is not
+	if !s.checkScriptAccess(w, r, ext, mod) {
+		return
+	}
+
+	logs = logs.WithField("module", mod)
 	logs.Info("resolving version")
-	resolved, err := s.Resolver.Resolve(owner, repo, version)
+	resolved, err := s.Resolver.Resolve(mod, version)
+
+	if errors.Is(err, gobinaries.ErrUnsupportedHost) {
+		logs.Warn("unsupported host")
+		s.render(w, "error"+ext, "Packages of this host are not supported")
+		return
+	}
 
 	if err == gobinaries.ErrNoVersions {
 		logs.Warn("no tags")
-		s.render(w, "error.sh", "Repository has no tags")
+		s.render(w, "error"+ext, "Repository has no tags")
 		return
 	}
 
 	if err == gobinaries.ErrNoVersionMatch {
 		logs.Warn("no match")
-		s.render(w, "error.sh", "Repository has no tags matching the requested version")
+		s.render(w, "error"+ext, "Repository has no tags, branches or commits matching the requested version")
 		return
 	}
 
 	if err != nil {
 		logs.WithError(err).Error("error resolving")
-		s.render(w, "error.sh", "Failed to resolve requested version")
+		s.render(w, "error"+ext, "Failed to resolve requested version")
 		return
 	}
 
 	logs = logs.WithField("resolved", resolved)
 	logs.Info("resolved version")
 
-	// rename package into go mod compatible name if v2 and above
-	major, err := getMajorVersion(resolved)
-	if err == nil && major > 1 {
-		modp := strings.Split(pkg, "/")
-		if len(modp) >= 3 {
-			mod := strings.Join(modp[:3], "/")
-			nested := strings.Join(modp[3:], "/")
-			pkg = fmt.Sprintf("%s/v%d/%s", mod, major, nested)
+	// deprecation notice, failures are not fatal
+	var deprecated string
+	if d, ok := s.Resolver.(gobinaries.DeprecationResolver); ok {
+		deprecated, err = d.ResolveDeprecation(mod)
+		if err != nil {
+			logs.WithError(err).Warn("error resolving deprecation")
 		}
 	}
 
-	s.render(w, "install.sh", struct {
+	// rename package into go mod compatible name, such as v2 and above
+	p := s.resolvePackage(mod, pkg, resolved, logs)
+
+	if !s.checkScriptAccess(w, r, ext, p.Path, p.Module) {
+		return
+	}
+
+	s.render(w, "install"+ext, struct {
 		URL             string
 		Package         string
+		Module          string
 		Binary          string
 		OriginalVersion string
 		Version         string
+		Commit          string
+		Deprecated      string
 	}{
 		URL:             s.URL,
-		Package:         pkg,
+		Package:         p.Path,
+		Module:          p.Module,
 		Binary:          bin,
 		OriginalVersion: version,
-		Version:         resolved,
+		Version:         p.Version,
+		Commit:          pseudoVersionCommit(p.Version),
+		Deprecated:      deprecated,
 	})
 }
 
@@ -177,39 +295,244 @@ func (s *Server) getScript(w http.ResponseWriter, r *http.Request) {
 // with the following required query-string parameters:
 //
 // - os
-// - arch
-// - version
+// - arch, where ARM variants such as "armv7" are supported
+// - version, a resolved module version
 //
-// For example "github.com/tj/triage/cmd/triage?os=linux&arch=amd64&version=1.0.0".
+// For example "github.com/tj/triage/cmd/triage?os=linux&arch=amd64&version=v1.0.0".
 //
+// The binary's SHA-256 checksum is sent in the Digest header field when known.
 func (s *Server) getBinary(w http.ResponseWriter, r *http.Request) {
 	start := time.Now()
+	bin, logs, ok := s.parseBinary(w, r)
+	if !ok {
+		return
+	}
+
+	obj, err := s.open(bin, logs)
+
+	if err == errQueueFull {
+		logs.Warn("build queue full")
+		w.Header().Set("Retry-After", strconv.Itoa(int(s.retryAfter()/time.Second)))
+		response.ServiceUnavailable(w, "Too many builds in progress, try again later")
+		return
+	}
+
+	if err != nil {
+		logs.WithError(err).Error("opening binary")
+		response.InternalServerError(w)
+		return
+	}
+	defer obj.Close()
+
+	logs.WithField("duration", duration(start)).Info("serving binary")
+	w.Header().Set("Content-Type", "application/octet-stream")
+	s.cacheControl(w, bin, "max-age=31536000, immutable")
+	attachment(w, bin)
+	if obj.sum != nil {
+		w.Header().Set("Digest", "sha-256="+base64.StdEncoding.EncodeToString(obj.sum))
+	}
+	if obj.size >= 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(obj.size, 10))
+	}
+	_, _ = io.Copy(w, obj)
+}
+
+// getChecksum responds with the SHA-256 checksum of the requested package
+// binary, in the format of sha256sum, accepting the same parameters as getBinary.
+// The binary is built when necessary.
+func (s *Server) getChecksum(w http.ResponseWriter, r *http.Request) {
+	bin, logs, ok := s.parseBinary(w, r)
+	if !ok {
+		return
+	}
+
+	sum, err := s.checksum(bin, logs)
+
+	if err == errQueueFull {
+		logs.Warn("build queue full")
+		w.Header().Set("Retry-After", strconv.Itoa(int(s.retryAfter()/time.Second)))
+		response.ServiceUnavailable(w, "Too many builds in progress, try again later")
+		return
+	}
+
+	if err != nil {
+		logs.WithError(err).Error("checksum")
+		response.InternalServerError(w)
+		return
+	}
+
+	logs.Info("serving checksum")
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	s.cacheControl(w, bin, "max-age=31536000, immutable")
+	fmt.Fprintf(w, "%x  %s\n", sum, filename(bin))
+}
+
+// getSignature responds with the base64 encoded ed25519 signature of the requested
+// package binary's checksum, accepting the same parameters as getBinary.
+func (s *Server) getSignature(w http.ResponseWriter, r *http.Request) {
+	if s.SigningKey == nil {
+		response.NotFound(w, "Signing is not enabled")
+		return
+	}
+
+	bin, logs, ok := s.parseBinary(w, r)
+	if !ok {
+		return
+	}
+
+	sig, err := s.signature(bin, logs)
+
+	if err == errQueueFull {
+		logs.Warn("build queue full")
+		w.Header().Set("Retry-After", strconv.Itoa(int(s.retryAfter()/time.Second)))
+		response.ServiceUnavailable(w, "Too many builds in progress, try again later")
+		return
+	}
+
+	if err == errUnsigned {
+		logs.Warn("binary not signed")
+		response.NotFound(w, "No signature found for the binary")
+		return
+	}
+
+	if err != nil {
+		logs.WithError(err).Error("signature")
+		response.InternalServerError(w)
+		return
+	}
+
+	logs.Info("serving signature")
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	s.cacheControl(w, bin, "no-cache")
+	fmt.Fprintln(w, base64.StdEncoding.EncodeToString(sig))
+}
+
+// getInfo responds with the JSON metadata of the requested package binary, accepting
+// the same parameters as getBinary. Binaries are not built by this endpoint.
+func (s *Server) getInfo(w http.ResponseWriter, r *http.Request) {
+	bin, logs, ok := s.parseBinary(w, r)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*15)
+	defer cancel()
+
+	obj, err := s.storage(bin).GetSidecar(ctx, bin, "json")
+
+	if err == gobinaries.ErrObjectNotFound {
+		response.NotFound(w, "No metadata found for the binary")
+		return
+	}
+
+	if err != nil {
+		logs.WithError(err).Error("fetching metadata from storage")
+		response.InternalServerError(w)
+		return
+	}
+	defer obj.Close()
+
+	var meta gobinaries.Metadata
+	err = json.NewDecoder(obj).Decode(&meta)
+	if err != nil {
+		logs.WithError(err).Error("decoding metadata")
+		response.InternalServerError(w)
+		return
+	}
+
+	logs.Info("serving metadata")
+	s.cacheControl(w, bin, "no-cache")
+	response.JSON(w, meta)
+}
+
+// getSigningKey responds with the PEM encoded public key of signatures.
+func (s *Server) getSigningKey(w http.ResponseWriter, r *http.Request) {
+	if s.SigningKey == nil {
+		response.NotFound(w, "Signing is not enabled")
+		return
+	}
+
+	b, err := x509.MarshalPKIXPublicKey(s.SigningKey.Public())
+	if err != nil {
+		log.WithError(err).Error("marshaling signing key")
+		response.InternalServerError(w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-pem-file")
+	w.Header().Set("Cache-Control", "no-cache")
+	_ = pem.Encode(w, &pem.Block{Type: "PUBLIC KEY", Bytes: b})
+}
+
+// parseBinary returns the binary requested by the query-string parameters,
+// responding with an error and returning false when they are invalid.
+func (s *Server) parseBinary(w http.ResponseWriter, r *http.Request) (gobinaries.Binary, log.Interface, bool) {
 	pkg := strings.TrimPrefix(r.URL.Path, "/")
 
 	if pkg == "" {
 		response.BadRequest(w)
-		return
+		return gobinaries.Binary{}, nil, false
+	}
+
+	if !s.checkAccess(w, r, pkg) {
+		return gobinaries.Binary{}, nil, false
 	}
 
 	goos := request.Param(r, "os")
 	if goos == "" {
 		response.BadRequest(w, "`os` parameter required")
-		return
+		return gobinaries.Binary{}, nil, false
+	}
+
+	if !platformRE.MatchString(goos) {
+		response.BadRequest(w, "`os` parameter is invalid")
+		return gobinaries.Binary{}, nil, false
 	}
 
 	arch := request.Param(r, "arch")
 	if arch == "" {
 		response.BadRequest(w, "`arch` parameter required")
-		return
+		return gobinaries.Binary{}, nil, false
+	}
+
+	if !platformRE.MatchString(arch) {
+		response.BadRequest(w, "`arch` parameter is invalid")
+		return gobinaries.Binary{}, nil, false
 	}
 
 	version := request.Param(r, "version")
 	if version == "" {
 		response.BadRequest(w, "`version` parameter required")
-		return
+		return gobinaries.Binary{}, nil, false
+	}
+
+	// resolved versions only, which are also used in storage keys
+	if !semver.IsValid(version) {
+		response.BadRequest(w, "`version` parameter is invalid")
+		return gobinaries.Binary{}, nil, false
+	}
+
+	// module, which is optional for compatibility with older install scripts
+	mod := request.Param(r, "module")
+	if mod != "" && !isPackageOf(pkg, mod) {
+		response.BadRequest(w, "`module` parameter must contain the package")
+		return gobinaries.Binary{}, nil, false
+	}
+
+	if mod == "" {
+		_, parsed, _, _ := parsePackage(pkg)
+		m, err := s.module(pkg, parsed)
+		if err != nil {
+			response.BadRequest(w, "module not found")
+			return gobinaries.Binary{}, nil, false
+		}
+		mod = m
+	}
+
+	if !s.checkAccess(w, r, mod) {
+		return gobinaries.Binary{}, nil, false
 	}
 
-	_, mod, _, _ := parsePackage(pkg)
 	logs := log.WithFields(log.Fields{
 		"ip":      r.Header.Get("CF-Connecting-IP"),
 		"package": pkg,
@@ -219,6 +542,24 @@ func (s *Server) getBinary(w http.ResponseWriter, r *http.Request) {
 		"version": version,
 	})
 
+	// reject platforms which cannot be built, responding with
+	// an error script which is run by the install script
+	if c, ok := s.Builder.(gobinaries.PlatformChecker); ok {
+		err := c.CheckPlatform(goos, arch)
+
+		if errors.Is(err, gobinaries.ErrUnsupportedPlatform) {
+			logs.Warn("unsupported platform")
+			s.renderStatus(w, http.StatusBadRequest, "error.sh", fmt.Sprintf("Binaries for %s/%s are not supported", goos, arch))
+			return gobinaries.Binary{}, nil, false
+		}
+
+		if err != nil {
+			logs.WithError(err).Error("checking platform")
+			response.InternalServerError(w)
+			return gobinaries.Binary{}, nil, false
+		}
+	}
+
 	bin := gobinaries.Binary{
 		Path:    pkg,
 		Module:  mod,
@@ -227,63 +568,526 @@ func (s *Server) getBinary(w http.ResponseWriter, r *http.Request) {
 		Arch:    arch,
 	}
 
-	// respond with the object if it already exists in storage
+	return bin, logs, true
+}
+
+// object is a binary opened from storage or a build.
+type object struct {
+	io.ReadCloser
+
+	// size in bytes, or -1 when unknown.
+	size int64
+
+	// sum is the SHA-256 checksum, or nil when unknown.
+	sum []byte
+
+	// sig is the signature of the checksum by the build, or nil when unknown.
+	sig []byte
+}
+
+// open returns the binary from storage when it exists, or a build which is still
+// being stored, otherwise it is built, or an identical build which is already
+// in-flight is awaited.
+func (s *Server) open(bin gobinaries.Binary, logs log.Interface) (*object, error) {
+	// built, and still being stored
+	if art := s.storing.get(bin); art != nil {
+		logs.Info("opening build being stored")
+		return openArtifact(art)
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*15)
 	defer cancel()
-	obj, err := s.Storage.Get(ctx, bin)
+
+	r, err := s.storage(bin).Get(ctx, bin)
+	switch {
+	case err == nil:
+		logs.Info("opening from storage")
+		return s.openStored(ctx, r, bin, logs)
+	case err == gobinaries.ErrObjectNotFound:
+		// build below
+	case errors.Is(err, gobinaries.ErrStorageUnavailable):
+		logs.WithError(err).Warn("storage unavailable, building")
+	default:
+		return nil, fmt.Errorf("fetching from storage: %w", err)
+	}
+
+	art, shared, err := s.builds.Do(bin, func() (*artifact, error) {
+		return s.build(bin, logs)
+	})
+
+	logs.WithField("shared", shared).Info("opening build")
+
+	if err != nil {
+		return nil, err
+	}
+
+	return openArtifact(art)
+}
+
+// openStored returns the stored binary with its stored checksum. Binaries stored
+// without a checksum are spooled to disk in order to store their checksum.
+func (s *Server) openStored(ctx context.Context, r io.ReadCloser, bin gobinaries.Binary, logs log.Interface) (*object, error) {
+	sum, err := s.storedChecksum(ctx, bin)
+
 	if err == nil {
-		logs.Info("serving from storage")
-		immutable(w)
-		_, _ = io.Copy(w, obj)
-		return
+		return &object{ReadCloser: r, size: -1, sum: sum}, nil
 	}
 
-	// build the binary, writing it to the response
-	// and buffering for cloud storage
-	var buf bytes.Buffer
-	logs.Info("building package")
-	immutable(w)
-	err = build.Write(io.MultiWriter(w, &buf), bin)
+	if err != gobinaries.ErrObjectNotFound {
+		logs.WithError(err).Warn("fetching checksum from storage")
+		return &object{ReadCloser: r, size: -1}, nil
+	}
+
+	defer r.Close()
+	art, err := newArtifact(func(f *os.File) error {
+		_, err := io.Copy(f, r)
+		return err
+	})
 	if err != nil {
-		logs.WithError(err).Error("building")
-		response.InternalServerError(w)
-		return
+		return nil, fmt.Errorf("spooling: %w", err)
 	}
-	logs.WithField("duration", duration(start)).Info("built package")
 
-	// store the binary
-	start = time.Now()
-	ctx, cancel = context.WithTimeout(context.Background(), time.Second*15)
+	err = s.storeChecksum(ctx, art, bin)
+	if err != nil {
+		logs.WithError(err).Error("storing checksum")
+	}
+
+	return openArtifact(art)
+}
+
+// openArtifact returns the artifact's binary, releasing the artifact once closed.
+func openArtifact(art *artifact) (*object, error) {
+	f, err := art.Open()
+	if err != nil {
+		art.Release()
+		return nil, fmt.Errorf("opening build: %w", err)
+	}
+
+	return &object{
+		ReadCloser: &artifactReader{File: f, art: art},
+		size:       art.size,
+		sum:        art.sum,
+		sig:        art.sig,
+	}, nil
+}
+
+// artifactReader is an artifact file, releasing the artifact once closed.
+type artifactReader struct {
+	*os.File
+	art *artifact
+}
+
+// Close implementation.
+func (a *artifactReader) Close() error {
+	err := a.File.Close()
+	a.art.Release()
+	return err
+}
+
+// checksum returns the binary's SHA-256 checksum from storage, or
+// from the binary itself, which is built when necessary.
+func (s *Server) checksum(bin gobinaries.Binary, logs log.Interface) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*15)
 	defer cancel()
-	logs.Info("storing package")
-	err = s.Storage.Create(ctx, &buf, bin)
+
+	sum, err := s.storedChecksum(ctx, bin)
 	if err == nil {
-		logs.WithField("duration", duration(start)).Info("stored package")
-	} else {
-		logs.WithError(err).Error("storing binary")
+		return sum, nil
+	}
+
+	if err != gobinaries.ErrObjectNotFound {
+		logs.WithError(err).Warn("fetching checksum from storage")
+	}
+
+	obj, err := s.open(bin, logs)
+	if err != nil {
+		return nil, err
+	}
+	defer obj.Close()
+
+	if obj.sum != nil {
+		return obj.sum, nil
+	}
+
+	h := sha256.New()
+	_, err = io.Copy(h, obj)
+	if err != nil {
+		return nil, fmt.Errorf("reading: %w", err)
+	}
+
+	return h.Sum(nil), nil
+}
+
+// errUnsigned is returned when a binary was not signed by its build.
+var errUnsigned = errors.New("binary not signed")
+
+// errInvalidSignature is returned when the stored signature of a binary's checksum
+// is invalid, such as when storage was tampered with, or the signing key changed.
+var errInvalidSignature = errors.New("invalid signature")
+
+// signature returns the signature of the binary's checksum from storage, verified
+// with the signing key, or the signature of the build when it is not stored yet.
+// Binaries are signed only when built, so that binaries written to storage by
+// anything else are never signed.
+func (s *Server) signature(bin gobinaries.Binary, logs log.Interface) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*15)
+	defer cancel()
+
+	sum, err := s.storedChecksum(ctx, bin)
+
+	// not stored yet, signed by the build
+	if err == gobinaries.ErrObjectNotFound {
+		obj, err := s.open(bin, logs)
+		if err != nil {
+			return nil, err
+		}
+		obj.Close()
+
+		if obj.sig == nil {
+			return nil, errUnsigned
+		}
+
+		return obj.sig, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("fetching checksum from storage: %w", err)
+	}
+
+	sig, err := s.storedSignature(ctx, bin)
+	if err == gobinaries.ErrObjectNotFound {
+		return nil, errUnsigned
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("fetching signature from storage: %w", err)
+	}
+
+	if !ed25519.Verify(s.SigningKey.Public().(ed25519.PublicKey), signatureMessage(bin, sum), sig) {
+		return nil, errInvalidSignature
+	}
+
+	return sig, nil
+}
+
+// sign returns the signature of the binary's checksum.
+func (s *Server) sign(bin gobinaries.Binary, sum []byte) []byte {
+	return ed25519.Sign(s.SigningKey, signatureMessage(bin, sum))
+}
+
+// signatureMessage returns the message signed for the binary's checksum, which
+// binds the checksum to the package, module, version and platform of the binary,
+// so that the signature of one binary is never valid for another. The install
+// scripts verify the same message, one "<field> <value>" line per field:
+//
+//	gobinaries-signature-v1
+//	path github.com/tj/triage/cmd/triage
+//	module github.com/tj/triage
+//	version v1.0.0
+//	os linux
+//	arch amd64
+//	sha256 <hex encoded checksum>
+func signatureMessage(bin gobinaries.Binary, sum []byte) []byte {
+	return []byte(fmt.Sprintf("gobinaries-signature-v1\npath %s\nmodule %s\nversion %s\nos %s\narch %s\nsha256 %x\n",
+		bin.Path, bin.Module, bin.Version, bin.OS, bin.Arch, sum))
+}
+
+// storedSignature returns the signature of the binary's checksum from storage.
+func (s *Server) storedSignature(ctx context.Context, bin gobinaries.Binary) ([]byte, error) {
+	r, err := s.storage(bin).GetSidecar(ctx, bin, "sig")
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	b, err := ioutil.ReadAll(io.LimitReader(r, 1<<10))
+	if err != nil {
+		return nil, fmt.Errorf("reading signature: %w", err)
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(b)))
+	if err != nil || len(sig) != ed25519.SignatureSize {
+		return nil, fmt.Errorf("invalid signature %q", b)
+	}
+
+	return sig, nil
+}
+
+// storedChecksum returns the binary's SHA-256 checksum from storage.
+func (s *Server) storedChecksum(ctx context.Context, bin gobinaries.Binary) ([]byte, error) {
+	r, err := s.storage(bin).GetSidecar(ctx, bin, "sha256")
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	b, err := ioutil.ReadAll(io.LimitReader(r, 1<<10))
+	if err != nil {
+		return nil, fmt.Errorf("reading checksum: %w", err)
+	}
+
+	sum, err := hex.DecodeString(strings.TrimSpace(string(b)))
+	if err != nil || len(sum) != sha256.Size {
+		return nil, fmt.Errorf("invalid checksum %q", b)
+	}
+
+	return sum, nil
+}
+
+// build builds the binary, spooling it to disk, and stores it in the background.
+// Storage errors are logged and do not fail the build.
+func (s *Server) build(bin gobinaries.Binary, logs log.Interface) (*artifact, error) {
+	// wait for a build slot
+	start := time.Now()
+	s.pool.init(s.concurrency(), s.queueSize())
+	s.storing.init(s.storeConcurrency())
+	depth, err := s.pool.Acquire()
+	logs = logs.WithField("queue_depth", depth)
+	if err != nil {
+		return nil, err
 	}
+	logs.WithField("wait", duration(start)).Info("acquired build slot")
 
-	// clear module cache
+	// build the binary, spooling to disk
 	start = time.Now()
-	err = build.ClearCache()
-	if err == nil {
-		logs.WithField("duration", duration(start)).Info("cleared cache")
-	} else {
-		logs.WithError(err).Error("clearing cache")
+	logs.Info("building package")
+	var build *gobinaries.Build
+	art, err := newArtifact(func(f *os.File) error {
+		if b, ok := s.Builder.(gobinaries.BuildWriter); ok {
+			info, err := b.WriteBuild(f, bin)
+			build = &info
+			return err
+		}
+		return s.Builder.Write(f, bin)
+	})
+	s.pool.Release()
+	if err != nil {
+		return nil, err
+	}
+	art.build = build
+	if s.SigningKey != nil {
+		art.sig = s.sign(bin, art.sum)
+	}
+	art.built = time.Now()
+	art.duration = duration(start)
+	logs.WithFields(log.Fields{
+		"duration": art.duration,
+		"size":     art.size,
+	}).Info("built package")
+
+	// store the binary in the background, outside of the build slot,
+	// serving the artifact to other requests until it is stored
+	art.Retain(1)
+	s.storing.add(bin, art)
+	store := func() {
+		defer art.Release()
+		defer s.storing.done(bin, art)
+
+		start := time.Now()
+		logs.Info("storing package")
+		ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+		defer cancel()
+		err := s.store(ctx, art, bin)
+		if err != nil {
+			logs.WithError(err).Error("storing binary")
+			return
+		}
+		logs.WithField("duration", duration(start)).Info("stored package")
+	}
+
+	// store before serving when too many binaries are being stored
+	if !s.storing.acquire() {
+		logs.Warn("storing package before serving, too many packages being stored")
+		store()
+		return art, nil
+	}
+
+	go func() {
+		defer s.storing.release()
+		store()
+	}()
+
+	return art, nil
+}
+
+// storeTimeout is the maximum duration of storing a binary with its sidecars.
+const storeTimeout = 5 * time.Minute
+
+// store the checksum, signature, metadata, and provenance of the artifact for
+// the given binary, followed by the binary itself, so that the sidecars of
+// stored binaries exist. The provenance is stored when the build is known.
+func (s *Server) store(ctx context.Context, art *artifact, bin gobinaries.Binary) error {
+	err := s.storeChecksum(ctx, art, bin)
+	if err != nil {
+		return err
+	}
+
+	err = s.storeMetadata(ctx, art, bin)
+	if err != nil {
+		return err
+	}
+
+	if art.build != nil {
+		err = s.storeProvenance(ctx, art, bin)
+		if err != nil {
+			return err
+		}
+	}
+
+	f, err := art.Open()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return s.storage(bin).Create(ctx, f, bin)
+}
+
+// storeMetadata stores the metadata of the artifact as the "json" sidecar of the binary.
+func (s *Server) storeMetadata(ctx context.Context, art *artifact, bin gobinaries.Binary) error {
+	meta := gobinaries.Metadata{
+		Binary:   bin,
+		Size:     art.size,
+		SHA256:   hex.EncodeToString(art.sum),
+		Duration: art.duration,
+		Built:    art.built.UTC(),
 	}
+
+	if art.build != nil {
+		meta.Toolchain = art.build.Toolchain
+		meta.Ldflags = art.build.Ldflags
+		meta.ModuleSum = art.build.Sum
+		meta.GoSum = art.build.GoSum
+	}
+
+	b, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshaling metadata: %w", err)
+	}
+
+	return s.storage(bin).CreateSidecar(ctx, bytes.NewReader(b), bin, "json")
 }
 
-// render template.
+// storeProvenance stores the provenance statement of the artifact as the "intoto.json" sidecar of the binary.
+func (s *Server) storeProvenance(ctx context.Context, art *artifact, bin gobinaries.Binary) error {
+	b, err := json.MarshalIndent(s.newStatement(bin, *art.build, art.sum), "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshaling provenance: %w", err)
+	}
+
+	return s.storage(bin).CreateSidecar(ctx, bytes.NewReader(b), bin, "intoto.json")
+}
+
+// storeChecksum stores the artifact's signature as the "sig" sidecar of the binary,
+// when signed by the build, followed by its checksum as the "sha256" sidecar.
+func (s *Server) storeChecksum(ctx context.Context, art *artifact, bin gobinaries.Binary) error {
+	if art.sig != nil {
+		err := s.storeSignature(ctx, art.sig, bin)
+		if err != nil {
+			return err
+		}
+	}
+
+	return s.storage(bin).CreateSidecar(ctx, strings.NewReader(hex.EncodeToString(art.sum)), bin, "sha256")
+}
+
+// storeSignature stores the signature of the checksum as the "sig" sidecar of the binary.
+func (s *Server) storeSignature(ctx context.Context, sig []byte, bin gobinaries.Binary) error {
+	return s.storage(bin).CreateSidecar(ctx, strings.NewReader(base64.StdEncoding.EncodeToString(sig)), bin, "sig")
+}
+
+// resolvePackage returns the package at the resolved version, found by the resolver
+// when it implements gobinaries.PackageResolver, otherwise the package is guessed.
+func (s *Server) resolvePackage(mod, pkg, version string, logs log.Interface) gobinaries.Package {
+	if r, ok := s.Resolver.(gobinaries.PackageResolver); ok {
+		p, err := r.ResolvePackage(mod, pkg, version)
+		if err == nil {
+			return p
+		}
+		logs.WithError(err).Warn("error resolving package")
+	}
+
+	return guessPackage(mod, pkg, version)
+}
+
+// module returns the module of the package, found by the resolver when it
+// implements gobinaries.ModuleResolver, otherwise the parsed module is used.
+func (s *Server) module(pkg, parsed string) (string, error) {
+	if r, ok := s.Resolver.(gobinaries.ModuleResolver); ok {
+		return r.ResolveModule(pkg)
+	}
+
+	if parsed == "" {
+		return "", fmt.Errorf("invalid package path %q", pkg)
+	}
+
+	return parsed, nil
+}
+
+// concurrency returns the maximum number of concurrent builds.
+func (s *Server) concurrency() int {
+	if s.Concurrency > 0 {
+		return s.Concurrency
+	}
+	return runtime.NumCPU()
+}
+
+// queueSize returns the maximum number of queued builds.
+func (s *Server) queueSize() int {
+	if s.QueueSize > 0 {
+		return s.QueueSize
+	}
+	return 100
+}
+
+// storeConcurrency returns the maximum number of binaries stored in the background.
+func (s *Server) storeConcurrency() int {
+	if s.StoreConcurrency > 0 {
+		return s.StoreConcurrency
+	}
+	return 10
+}
+
+// retryAfter returns the delay suggested to clients when the build queue is full.
+func (s *Server) retryAfter() time.Duration {
+	if s.RetryAfter > 0 {
+		return s.RetryAfter
+	}
+	return 30 * time.Second
+}
+
+// templateDir returns the script template directory.
+func (s *Server) templateDir() string {
+	if s.Templates != "" {
+		return s.Templates
+	}
+	return "templates"
+}
+
+// render template, with the content type of a PowerShell
+// script for ".ps1" templates, otherwise a shell script.
 func (s *Server) render(w http.ResponseWriter, name string, data interface{}) {
-	w.Header().Set("Content-Type", "application/x-sh")
+	s.renderStatus(w, http.StatusOK, name, data)
+}
+
+// renderStatus renders the template with the given status code.
+func (s *Server) renderStatus(w http.ResponseWriter, code int, name string, data interface{}) {
+	if filepath.Ext(name) == ".ps1" {
+		w.Header().Set("Content-Type", "application/x-powershell")
+	} else {
+		w.Header().Set("Content-Type", "application/x-sh")
+	}
 	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
 	s.templates.ExecuteTemplate(w, name, data)
 }
 
-// immutable sets immutability header fields.
-func immutable(w http.ResponseWriter) {
-	w.Header().Set("Content-Type", "application/octet-stream")
-	w.Header().Set("Cache-Control", "max-age=31536000, immutable")
+// attachment sets the Content-Disposition header field to the binary's file name,
+// such as "staticgen.exe" for Windows.
+func attachment(w http.ResponseWriter, bin gobinaries.Binary) {
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+		"filename": filename(bin),
+	}))
 }
 
 // duration returns the duration since start in milliseconds.
@@ -0,0 +1,1142 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tj/assert"
+
+	"github.com/tj/gobinaries"
+)
+
+// memoryStorage is an in-memory storage implementation.
+type memoryStorage struct {
+	mu       sync.Mutex
+	objects  map[gobinaries.Binary][]byte
+	sidecars map[string][]byte
+	creates  int
+	files    []string
+}
+
+// Create implementation.
+func (m *memoryStorage) Create(ctx context.Context, r io.Reader, bin gobinaries.Binary) error {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.objects == nil {
+		m.objects = make(map[gobinaries.Binary][]byte)
+	}
+	m.objects[bin] = b
+	m.creates++
+	if f, ok := r.(*os.File); ok {
+		m.files = append(m.files, f.Name())
+	}
+	return nil
+}
+
+// Get implementation.
+func (m *memoryStorage) Get(ctx context.Context, bin gobinaries.Binary) (io.ReadCloser, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b, ok := m.objects[bin]
+	if !ok {
+		return nil, gobinaries.ErrObjectNotFound
+	}
+	return ioutil.NopCloser(bytes.NewReader(b)), nil
+}
+
+// CreateSidecar implementation.
+func (m *memoryStorage) CreateSidecar(ctx context.Context, r io.Reader, bin gobinaries.Binary, name string) error {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.sidecars == nil {
+		m.sidecars = make(map[string][]byte)
+	}
+	m.sidecars[fmt.Sprintf("%v.%s", bin, name)] = b
+	return nil
+}
+
+// GetSidecar implementation.
+func (m *memoryStorage) GetSidecar(ctx context.Context, bin gobinaries.Binary, name string) (io.ReadCloser, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b, ok := m.sidecars[fmt.Sprintf("%v.%s", bin, name)]
+	if !ok {
+		return nil, gobinaries.ErrObjectNotFound
+	}
+	return ioutil.NopCloser(bytes.NewReader(b)), nil
+}
+
+// errorStorage is a storage implementation failing with the given errors.
+type errorStorage struct {
+	get    error
+	create error
+}
+
+// Create implementation.
+func (e *errorStorage) Create(ctx context.Context, r io.Reader, bin gobinaries.Binary) error {
+	_, _ = io.CopyN(ioutil.Discard, r, 5)
+	return e.create
+}
+
+// Get implementation.
+func (e *errorStorage) Get(ctx context.Context, bin gobinaries.Binary) (io.ReadCloser, error) {
+	return nil, e.get
+}
+
+// CreateSidecar implementation.
+func (e *errorStorage) CreateSidecar(ctx context.Context, r io.Reader, bin gobinaries.Binary, name string) error {
+	return e.create
+}
+
+// GetSidecar implementation.
+func (e *errorStorage) GetSidecar(ctx context.Context, bin gobinaries.Binary, name string) (io.ReadCloser, error) {
+	return nil, e.get
+}
+
+// fakeBuilder is a builder writing the binary's details, blocking until released.
+type fakeBuilder struct {
+	mu      sync.Mutex
+	release chan struct{}
+	builds  int
+}
+
+// Write implementation.
+func (f *fakeBuilder) Write(w io.Writer, bin gobinaries.Binary) error {
+	f.mu.Lock()
+	f.builds++
+	f.mu.Unlock()
+
+	if f.release != nil {
+		<-f.release
+	}
+
+	_, err := io.WriteString(w, bin.Path+"@"+bin.Version+" "+bin.OS+"/"+bin.Arch)
+	return err
+}
+
+// fakeResolver is a resolver resolving every version to v1.2.0, with a deprecation message.
+type fakeResolver struct {
+	err error
+}
+
+// Resolve implementation.
+func (f *fakeResolver) Resolve(mod, version string) (string, error) {
+	if f.err != nil {
+		return "", f.err
+	}
+	return "v1.2.0", nil
+}
+
+// ResolveDeprecation implementation.
+func (f *fakeResolver) ResolveDeprecation(mod string) (string, error) {
+	return "it's 100% replaced", nil
+}
+
+// moduleResolver is a resolver with module paths which differ from package paths.
+type moduleResolver struct {
+	fakeResolver
+	modules map[string]string
+}
+
+// ResolveModule implementation.
+func (m *moduleResolver) ResolveModule(pkg string) (string, error) {
+	mod, ok := m.modules[pkg]
+	if !ok {
+		return "", fmt.Errorf("no module of %q", pkg)
+	}
+	return mod, nil
+}
+
+// waitForWaiters blocks until n requests are waiting on the in-flight build of bin.
+func waitForWaiters(t testing.TB, s *Server, bin gobinaries.Binary, n int) {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		s.builds.mu.Lock()
+		c, ok := s.builds.calls[bin]
+		dups := 0
+		if ok {
+			dups = c.dups
+		}
+		s.builds.mu.Unlock()
+
+		if dups == n {
+			return
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %d waiters", n)
+}
+
+// Test deduplication of concurrent identical builds.
+func TestServer_getBinary_concurrent(t *testing.T) {
+	storage := &memoryStorage{}
+	builder := &fakeBuilder{release: make(chan struct{})}
+	s := &Server{
+		Storage: storage,
+		Builder: builder,
+	}
+
+	bin := gobinaries.Binary{
+		Path:    "github.com/tj/triage/cmd/triage",
+		Module:  "github.com/tj/triage",
+		Version: "v1.0.0",
+		OS:      "linux",
+		Arch:    "amd64",
+	}
+
+	n := 10
+	var wg sync.WaitGroup
+	responses := make([]*httptest.ResponseRecorder, n)
+
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("GET", "/github.com/tj/triage/cmd/triage?os=linux&arch=amd64&version=v1.0.0", nil)
+			s.getBinary(w, r)
+			s.storing.wait()
+			responses[i] = w
+		}(i)
+	}
+
+	waitForWaiters(t, s, bin, n-1)
+	close(builder.release)
+	wg.Wait()
+
+	assert.Equal(t, 1, builder.builds)
+	assert.Equal(t, 1, storage.creates)
+
+	for _, w := range responses {
+		assert.Equal(t, 200, w.Code)
+		assert.Equal(t, "github.com/tj/triage/cmd/triage@v1.0.0 linux/amd64", w.Body.String())
+		assert.Equal(t, "50", w.Header().Get("Content-Length"))
+	}
+
+	t.Run("removes the artifact", func(t *testing.T) {
+		assert.Len(t, storage.files, 1)
+		_, err := os.Stat(storage.files[0])
+		assert.True(t, os.IsNotExist(err))
+	})
+
+	t.Run("distinct binaries", func(t *testing.T) {
+		builder := &fakeBuilder{}
+		storage := &memoryStorage{}
+		s := &Server{
+			Storage: storage,
+			Builder: builder,
+		}
+
+		for _, arch := range []string{"amd64", "arm64"} {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("GET", "/github.com/tj/triage/cmd/triage?os=linux&arch="+arch+"&version=v1.0.0", nil)
+			s.getBinary(w, r)
+			s.storing.wait()
+			assert.Equal(t, "github.com/tj/triage/cmd/triage@v1.0.0 linux/"+arch, w.Body.String())
+		}
+
+		assert.Equal(t, 2, builder.builds)
+		assert.Equal(t, 2, storage.creates)
+	})
+}
+
+// Test that storage failures do not affect the response.
+func TestServer_getBinary_storageFailure(t *testing.T) {
+	s := &Server{
+		Storage: &errorStorage{
+			get:    gobinaries.ErrObjectNotFound,
+			create: errors.New("boom"),
+		},
+		Builder: &fakeBuilder{},
+	}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/github.com/tj/triage/cmd/triage?os=linux&arch=amd64&version=v1.0.0", nil)
+	s.getBinary(w, r)
+	s.storing.wait()
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "github.com/tj/triage/cmd/triage@v1.0.0 linux/amd64", w.Body.String())
+}
+
+// Test falling back to builds based on the storage error.
+func TestServer_getBinary_storageErrors(t *testing.T) {
+	cases := []struct {
+		name  string
+		err   error
+		code  int
+		built bool
+	}{
+		{"not found", gobinaries.ErrObjectNotFound, 200, true},
+		{"transient", fmt.Errorf("%w: timeout", gobinaries.ErrStorageUnavailable), 200, true},
+		{"permission", fmt.Errorf("%w: 403", gobinaries.ErrStorageForbidden), 500, false},
+		{"unknown", errors.New("boom"), 500, false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			builder := &fakeBuilder{}
+			s := &Server{
+				Storage: &errorStorage{get: c.err},
+				Builder: builder,
+			}
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("GET", "/github.com/tj/triage/cmd/triage?os=linux&arch=amd64&version=v1.0.0", nil)
+			s.getBinary(w, r)
+			s.storing.wait()
+			assert.Equal(t, c.code, w.Code)
+			assert.Equal(t, c.built, builder.builds == 1)
+		})
+	}
+}
+
+// blockingStorage is a memory storage blocking object creation until released.
+type blockingStorage struct {
+	memoryStorage
+	release chan struct{}
+}
+
+// Create implementation.
+func (b *blockingStorage) Create(ctx context.Context, r io.Reader, bin gobinaries.Binary) error {
+	<-b.release
+	return b.memoryStorage.Create(ctx, r, bin)
+}
+
+// Test responding before the binary is stored.
+func TestServer_getBinary_slowStorage(t *testing.T) {
+	storage := &blockingStorage{release: make(chan struct{})}
+	builder := &fakeBuilder{}
+	s := &Server{
+		Storage:     storage,
+		Builder:     builder,
+		Concurrency: 1,
+		QueueSize:   1,
+	}
+
+	get := func(arch string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/github.com/tj/triage/cmd/triage?os=linux&arch="+arch+"&version=v1.0.0", nil)
+		s.getBinary(w, r)
+		return w
+	}
+
+	w := get("amd64")
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "github.com/tj/triage/cmd/triage@v1.0.0 linux/amd64", w.Body.String())
+
+	// served from the build being stored
+	w = get("amd64")
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "github.com/tj/triage/cmd/triage@v1.0.0 linux/amd64", w.Body.String())
+	assert.Equal(t, 1, builder.builds)
+
+	// the build slot is not held while storing
+	w = get("arm64")
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, 2, builder.builds)
+
+	close(storage.release)
+	s.storing.wait()
+	assert.Equal(t, 2, storage.creates)
+
+	assert.Len(t, storage.files, 2)
+	for _, path := range storage.files {
+		_, err := os.Stat(path)
+		assert.True(t, os.IsNotExist(err))
+	}
+}
+
+// Test storing before serving when too many binaries are being stored.
+func TestServer_getBinary_storeConcurrency(t *testing.T) {
+	storage := &blockingStorage{release: make(chan struct{})}
+	s := &Server{
+		Storage:          storage,
+		Builder:          &fakeBuilder{},
+		StoreConcurrency: 1,
+	}
+
+	get := func(arch string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/github.com/tj/triage/cmd/triage?os=linux&arch="+arch+"&version=v1.0.0", nil)
+		s.getBinary(w, r)
+		return w
+	}
+
+	// stored in the background
+	w := get("amd64")
+	assert.Equal(t, 200, w.Code)
+
+	// stored before serving
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		done <- get("arm64")
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("served before storing")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(storage.release)
+	w = <-done
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "github.com/tj/triage/cmd/triage@v1.0.0 linux/arm64", w.Body.String())
+
+	s.storing.wait()
+	assert.Equal(t, 2, storage.creates)
+}
+
+// Test bounding concurrent builds.
+func TestServer_getBinary_queue(t *testing.T) {
+	builder := &fakeBuilder{release: make(chan struct{})}
+	s := &Server{
+		Storage:     &memoryStorage{},
+		Builder:     builder,
+		Concurrency: 1,
+		QueueSize:   1,
+		RetryAfter:  time.Minute,
+	}
+
+	get := func(arch string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/github.com/tj/triage/cmd/triage?os=linux&arch="+arch+"&version=v1.0.0", nil)
+		s.getBinary(w, r)
+		return w
+	}
+
+	var wg sync.WaitGroup
+	responses := make([]*httptest.ResponseRecorder, 2)
+	for i, arch := range []string{"amd64", "arm64"} {
+		wg.Add(1)
+		go func(i int, arch string) {
+			defer wg.Done()
+			responses[i] = get(arch)
+		}(i, arch)
+	}
+
+	// wait for one build to run and one to be queued
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		s.pool.mu.Lock()
+		queued := s.pool.queued
+		s.pool.mu.Unlock()
+
+		builder.mu.Lock()
+		builds := builder.builds
+		builder.mu.Unlock()
+
+		if queued == 1 && builds == 1 {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for queued build")
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+
+	w := get("386")
+	assert.Equal(t, 503, w.Code)
+	assert.Equal(t, "60", w.Header().Get("Retry-After"))
+
+	close(builder.release)
+	wg.Wait()
+
+	for _, w := range responses {
+		assert.Equal(t, 200, w.Code)
+	}
+	assert.Equal(t, 2, builder.builds)
+}
+
+// Test serving the shell and PowerShell install scripts.
+func TestServer_getScript(t *testing.T) {
+	s := &Server{
+		URL:       "https://gobinaries.com",
+		Templates: "../templates",
+		Resolver:  &fakeResolver{},
+	}
+
+	get := func(path, accept string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", path, nil)
+		if accept != "" {
+			r.Header.Set("Accept", accept)
+		}
+		s.ServeHTTP(w, r)
+		return w
+	}
+
+	t.Run("shell", func(t *testing.T) {
+		w := get("/tj/triage/cmd/triage@1.x", "")
+		body := w.Body.String()
+		assert.Equal(t, 200, w.Code)
+		assert.Equal(t, "application/x-sh", w.Header().Get("Content-Type"))
+		assert.Equal(t, "Accept", w.Header().Get("Vary"))
+		assert.Contains(t, body, `pkg="github.com/tj/triage/cmd/triage"`)
+		assert.Contains(t, body, `bin="triage"`)
+		assert.Contains(t, body, `bin="$bin.exe"`)
+		assert.Contains(t, body, `original_version="1.x"`)
+		assert.Contains(t, body, `deprecated='it'\''s 100%% replaced'`)
+		assert.Contains(t, body, `&version=v1.2.0&module=github.com%2Ftj%2Ftriage"`)
+
+		if _, err := exec.LookPath("sh"); err == nil {
+			cmd := exec.Command("sh", "-n")
+			cmd.Stdin = strings.NewReader(body)
+			out, err := cmd.CombinedOutput()
+			assert.NoError(t, err, string(out))
+		}
+	})
+
+	for _, c := range []struct {
+		name   string
+		path   string
+		accept string
+	}{
+		{"powershell suffix", "/tj/triage/cmd/triage@1.x.ps1", ""},
+		{"powershell accept", "/tj/triage/cmd/triage@1.x", "application/x-powershell"},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			w := get(c.path, c.accept)
+			body := w.Body.String()
+			assert.Equal(t, 200, w.Code)
+			assert.Equal(t, "application/x-powershell", w.Header().Get("Content-Type"))
+			assert.Contains(t, body, `$pkg = 'github.com/tj/triage/cmd/triage'`)
+			assert.Contains(t, body, `$bin = 'triage.exe'`)
+			assert.Contains(t, body, `$original_version = '1.x'`)
+			assert.Contains(t, body, `$deprecated = 'it''s 100% replaced'`)
+			assert.Contains(t, body, `'?os=windows&arch=' + $arch + '&version=v1.2.0&module=github.com%2Ftj%2Ftriage'`)
+		})
+	}
+
+	t.Run("errors", func(t *testing.T) {
+		s := &Server{
+			Templates: "../templates",
+			Resolver:  &fakeResolver{err: gobinaries.ErrNoVersions},
+		}
+
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest("GET", "/tj/triage", nil))
+		assert.Equal(t, "application/x-sh", w.Header().Get("Content-Type"))
+		assert.Contains(t, w.Body.String(), `Error:\033[0;00m Repository has no tags`)
+
+		w = httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest("GET", "/tj/triage.ps1", nil))
+		assert.Equal(t, "application/x-powershell", w.Header().Get("Content-Type"))
+		assert.Contains(t, w.Body.String(), "Write-Host 'Repository has no tags'")
+	})
+}
+
+// Test naming binaries with the Content-Disposition header field.
+func TestServer_getBinary_filename(t *testing.T) {
+	s := &Server{
+		Storage: &memoryStorage{},
+		Builder: &fakeBuilder{},
+	}
+
+	for _, goos := range []string{"windows", "linux", "windows"} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/github.com/tj/triage/cmd/triage?os="+goos+"&arch=amd64&version=v1.0.0", nil)
+		s.getBinary(w, r)
+		s.storing.wait()
+		assert.Equal(t, 200, w.Code)
+
+		if goos == "windows" {
+			assert.Equal(t, `attachment; filename=triage.exe`, w.Header().Get("Content-Disposition"))
+		} else {
+			assert.Equal(t, `attachment; filename=triage`, w.Header().Get("Content-Disposition"))
+		}
+	}
+}
+
+// platformBuilder is a fake builder supporting linux binaries only.
+type platformBuilder struct {
+	fakeBuilder
+}
+
+// CheckPlatform implementation.
+func (p *platformBuilder) CheckPlatform(os, arch string) error {
+	if os != "linux" {
+		return fmt.Errorf("%w: %s/%s", gobinaries.ErrUnsupportedPlatform, os, arch)
+	}
+	return nil
+}
+
+// Test responding with an error script for unsupported platforms.
+func TestServer_getBinary_platform(t *testing.T) {
+	builder := &platformBuilder{}
+	s := &Server{
+		Templates: "../templates",
+		Storage:   &memoryStorage{},
+		Builder:   builder,
+	}
+
+	cases := []struct {
+		query string
+		code  int
+		body  string
+	}{
+		{"os=linux&arch=armv7", 200, "github.com/tj/triage/cmd/triage@v1.0.0 linux/armv7"},
+		{"os=plan9&arch=armv7", 400, "Error:\\033[0;00m Binaries for plan9/armv7 are not supported"},
+		{"os=linux&arch=$(reboot)", 400, "`arch` parameter is invalid"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.query, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("GET", "/binary/github.com/tj/triage/cmd/triage?version=v1.0.0&"+strings.Replace(c.query, "$", "%24", -1), nil)
+			s.ServeHTTP(w, r)
+			s.storing.wait()
+			assert.Equal(t, c.code, w.Code)
+			assert.Contains(t, w.Body.String(), c.body)
+		})
+	}
+
+	assert.Equal(t, 1, builder.builds)
+}
+
+// Test serving binary checksums.
+func TestServer_getBinary_version(t *testing.T) {
+	storage := &memoryStorage{}
+	builder := &fakeBuilder{}
+	s := &Server{
+		Templates: "../templates",
+		Storage:   storage,
+		Builder:   builder,
+	}
+
+	for _, version := range []string{"../../../leak", "v1.0.0/..", "1.0.0", "master"} {
+		t.Run(version, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("GET", "/binary/github.com/tj/x?os=linux&arch=amd64&module=github.com%2Ftj%2Fx&version="+url.QueryEscape(version), nil)
+			s.ServeHTTP(w, r)
+			s.storing.wait()
+			assert.Equal(t, 400, w.Code)
+			assert.Contains(t, w.Body.String(), "`version` parameter is invalid")
+		})
+	}
+
+	assert.Equal(t, 0, builder.builds)
+	assert.Len(t, storage.sidecars, 0)
+}
+
+func TestServer_getChecksum(t *testing.T) {
+	body := "github.com/tj/triage/cmd/triage@v1.0.0 linux/amd64"
+	sum := sha256.Sum256([]byte(body))
+	query := "?os=linux&arch=amd64&version=v1.0.0"
+
+	bin := gobinaries.Binary{
+		Path:    "github.com/tj/triage/cmd/triage",
+		Module:  "github.com/tj/triage",
+		Version: "v1.0.0",
+		OS:      "linux",
+		Arch:    "amd64",
+	}
+
+	get := func(s *Server, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		s.storing.wait()
+		return w
+	}
+
+	t.Run("built", func(t *testing.T) {
+		storage := &memoryStorage{}
+		builder := &fakeBuilder{}
+		s := &Server{
+			Templates: "../templates",
+			Storage:   storage,
+			Builder:   builder,
+		}
+
+		w := get(s, "/checksum/github.com/tj/triage/cmd/triage"+query)
+		assert.Equal(t, 200, w.Code)
+		assert.Equal(t, fmt.Sprintf("%x  triage\n", sum), w.Body.String())
+
+		w = get(s, "/binary/github.com/tj/triage/cmd/triage"+query)
+		assert.Equal(t, 200, w.Code)
+		assert.Equal(t, body, w.Body.String())
+		assert.Equal(t, "sha-256="+base64.StdEncoding.EncodeToString(sum[:]), w.Header().Get("Digest"))
+
+		assert.Equal(t, 1, builder.builds)
+		assert.Equal(t, fmt.Sprintf("%x", sum), string(storage.sidecars[fmt.Sprintf("%v.sha256", bin)]))
+	})
+
+	t.Run("stored without checksum", func(t *testing.T) {
+		storage := &memoryStorage{
+			objects: map[gobinaries.Binary][]byte{
+				bin: []byte(body),
+			},
+		}
+		builder := &fakeBuilder{}
+		s := &Server{
+			Templates: "../templates",
+			Storage:   storage,
+			Builder:   builder,
+		}
+
+		w := get(s, "/binary/github.com/tj/triage/cmd/triage"+query)
+		assert.Equal(t, 200, w.Code)
+		assert.Equal(t, body, w.Body.String())
+		assert.Equal(t, "sha-256="+base64.StdEncoding.EncodeToString(sum[:]), w.Header().Get("Digest"))
+		assert.Equal(t, fmt.Sprintf("%x", sum), string(storage.sidecars[fmt.Sprintf("%v.sha256", bin)]))
+
+		w = get(s, "/checksum/github.com/tj/triage/cmd/triage"+query)
+		assert.Equal(t, 200, w.Code)
+		assert.Equal(t, fmt.Sprintf("%x  triage\n", sum), w.Body.String())
+
+		assert.Equal(t, 0, builder.builds)
+	})
+}
+
+// Test signing binary checksums.
+func TestServer_getSignature(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(nil)
+	assert.NoError(t, err)
+
+	body := "github.com/tj/triage/cmd/triage@v1.0.0 linux/amd64"
+	sum := sha256.Sum256([]byte(body))
+	msg := []byte(fmt.Sprintf("gobinaries-signature-v1\npath github.com/tj/triage/cmd/triage\nmodule github.com/tj/triage\nversion v1.0.0\nos linux\narch amd64\nsha256 %x\n", sum))
+	path := "/signature/github.com/tj/triage/cmd/triage?os=linux&arch=amd64&version=v1.0.0"
+
+	bin := gobinaries.Binary{
+		Path:    "github.com/tj/triage/cmd/triage",
+		Module:  "github.com/tj/triage",
+		Version: "v1.0.0",
+		OS:      "linux",
+		Arch:    "amd64",
+	}
+
+	get := func(s *Server, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		s.storing.wait()
+		return w
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		s := &Server{
+			Templates: "../templates",
+			Storage:   &memoryStorage{},
+			Builder:   &fakeBuilder{},
+		}
+
+		assert.Equal(t, 404, get(s, path).Code)
+		assert.Equal(t, 404, get(s, "/signing-key.pem").Code)
+	})
+
+	t.Run("signed", func(t *testing.T) {
+		storage := &memoryStorage{}
+		s := &Server{
+			Templates:  "../templates",
+			Storage:    storage,
+			Builder:    &fakeBuilder{},
+			SigningKey: key,
+		}
+
+		w := get(s, path)
+		assert.Equal(t, 200, w.Code)
+
+		sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(w.Body.String()))
+		assert.NoError(t, err)
+		assert.True(t, ed25519.Verify(key.Public().(ed25519.PublicKey), msg, sig))
+		assert.Equal(t, strings.TrimSpace(w.Body.String()), string(storage.sidecars[fmt.Sprintf("%v.sig", bin)]))
+
+		w = get(s, "/signing-key.pem")
+		assert.Equal(t, 200, w.Code)
+		block, _ := pem.Decode(w.Body.Bytes())
+		pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+		assert.NoError(t, err)
+		assert.Equal(t, key.Public(), pub)
+
+		t.Run("openssl", func(t *testing.T) {
+			if _, err := exec.LookPath("openssl"); err != nil {
+				t.Skip("openssl is not installed")
+			}
+
+			dir, err := ioutil.TempDir("", "gobinaries-signature")
+			assert.NoError(t, err)
+			defer os.RemoveAll(dir)
+
+			assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "key.pem"), w.Body.Bytes(), 0644))
+			assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "msg"), msg, 0644))
+			assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "sig"), sig, 0644))
+
+			cmd := exec.Command("openssl", "pkeyutl", "-verify", "-pubin", "-inkey", "key.pem", "-rawin", "-in", "msg", "-sigfile", "sig")
+			cmd.Dir = dir
+			out, err := cmd.CombinedOutput()
+			assert.NoError(t, err, string(out))
+		})
+	})
+
+	t.Run("signed with another key", func(t *testing.T) {
+		_, other, err := ed25519.GenerateKey(nil)
+		assert.NoError(t, err)
+
+		storage := &memoryStorage{}
+		s := &Server{
+			Templates:  "../templates",
+			Storage:    storage,
+			Builder:    &fakeBuilder{},
+			SigningKey: other,
+		}
+
+		assert.Equal(t, 200, get(s, path).Code)
+
+		s.SigningKey = key
+		w := get(s, path)
+		assert.Equal(t, 500, w.Code)
+		assert.Equal(t, base64.StdEncoding.EncodeToString(ed25519.Sign(other, msg)), string(storage.sidecars[fmt.Sprintf("%v.sig", bin)]))
+	})
+
+	t.Run("tampered binary", func(t *testing.T) {
+		tampered := []byte("malicious")
+		storage := &memoryStorage{
+			objects: map[gobinaries.Binary][]byte{
+				bin: tampered,
+			},
+		}
+		builder := &fakeBuilder{}
+		s := &Server{
+			Templates:  "../templates",
+			Storage:    storage,
+			Builder:    builder,
+			SigningKey: key,
+		}
+
+		w := get(s, path)
+		assert.Equal(t, 404, w.Code)
+		assert.Equal(t, 0, builder.builds)
+		assert.Nil(t, storage.sidecars[fmt.Sprintf("%v.sig", bin)])
+	})
+
+	t.Run("tampered checksum", func(t *testing.T) {
+		storage := &memoryStorage{}
+		s := &Server{
+			Templates:  "../templates",
+			Storage:    storage,
+			Builder:    &fakeBuilder{},
+			SigningKey: key,
+		}
+
+		assert.Equal(t, 200, get(s, path).Code)
+
+		tampered := sha256.Sum256([]byte("malicious"))
+		storage.sidecars[fmt.Sprintf("%v.sha256", bin)] = []byte(fmt.Sprintf("%x", tampered))
+
+		w := get(s, path)
+		assert.Equal(t, 500, w.Code)
+		assert.NotContains(t, w.Body.String(), string(storage.sidecars[fmt.Sprintf("%v.sig", bin)]))
+	})
+
+	t.Run("signature of another binary", func(t *testing.T) {
+		storage := &memoryStorage{}
+		s := &Server{
+			Templates:  "../templates",
+			Storage:    storage,
+			Builder:    &fakeBuilder{},
+			SigningKey: key,
+		}
+
+		old := bin
+		old.Version = "v0.9.0"
+		assert.Equal(t, 200, get(s, strings.Replace(path, "v1.0.0", "v0.9.0", 1)).Code)
+		assert.Equal(t, 200, get(s, path).Code)
+
+		// the older binary served in place of the requested binary
+		storage.objects[bin] = storage.objects[old]
+		for _, ext := range []string{"sha256", "sig"} {
+			storage.sidecars[fmt.Sprintf("%v.%s", bin, ext)] = storage.sidecars[fmt.Sprintf("%v.%s", old, ext)]
+		}
+
+		assert.Equal(t, 500, get(s, path).Code)
+	})
+
+	t.Run("missing signature", func(t *testing.T) {
+		storage := &memoryStorage{}
+		s := &Server{
+			Templates:  "../templates",
+			Storage:    storage,
+			Builder:    &fakeBuilder{},
+			SigningKey: key,
+		}
+
+		assert.Equal(t, 200, get(s, path).Code)
+		delete(storage.sidecars, fmt.Sprintf("%v.sig", bin))
+
+		w := get(s, path)
+		assert.Equal(t, 404, w.Code)
+		assert.Nil(t, storage.sidecars[fmt.Sprintf("%v.sig", bin)])
+	})
+}
+
+// buildWriter is a fake builder reporting the details of builds.
+type buildWriter struct {
+	fakeBuilder
+}
+
+// WriteBuild implementation.
+func (b *buildWriter) WriteBuild(w io.Writer, bin gobinaries.Binary) (gobinaries.Build, error) {
+	err := b.Write(w, bin)
+	return gobinaries.Build{
+		Toolchain:    "go1.21.5",
+		Flags:        []string{"-trimpath"},
+		Ldflags:      "-buildid=",
+		Env:          []string{"GOOS=" + bin.OS, "GOPROXY=https://proxy.golang.org,direct", "GOTOOLCHAIN=go1.21.5"},
+		Reproducible: true,
+		Sum:          "h1:Abcdef=",
+		GoSum: []string{
+			"github.com/pkg/errors v0.9.1 h1:FEBLx1zS214owpjy7qsBeixbURkuhQAwrK5UwLGTwt4=",
+			"github.com/pkg/errors v0.9.1/go.mod h1:bwawxfHBFNV+L2hUp1rHADufV3IMtnDRdf1r5NINEl0=",
+			"github.com/tj/triage v1.0.0 h1:Abcdef=",
+			"github.com/tj/triage v1.0.0/go.mod h1:Ghijkl=",
+		},
+		Started:  time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC),
+		Finished: time.Date(2020, 1, 1, 12, 0, 5, 0, time.UTC),
+	}, err
+}
+
+// Test storing the provenance of builds.
+func TestServer_getBinary_provenance(t *testing.T) {
+	storage := &memoryStorage{}
+	s := &Server{
+		URL:     "https://gobinaries.com",
+		Storage: storage,
+		Builder: &buildWriter{},
+	}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/github.com/tj/triage/cmd/triage?os=linux&arch=amd64&version=v1.0.0&module=github.com/tj/triage", nil)
+	s.getBinary(w, r)
+	s.storing.wait()
+	assert.Equal(t, 200, w.Code)
+
+	bin := gobinaries.Binary{
+		Path:    "github.com/tj/triage/cmd/triage",
+		Module:  "github.com/tj/triage",
+		Version: "v1.0.0",
+		OS:      "linux",
+		Arch:    "amd64",
+	}
+
+	var st statement
+	err := json.Unmarshal(storage.sidecars[fmt.Sprintf("%v.intoto.json", bin)], &st)
+	assert.NoError(t, err)
+
+	sum := sha256.Sum256(w.Body.Bytes())
+	assert.Equal(t, "https://slsa.dev/provenance/v0.2", st.PredicateType)
+	assert.Equal(t, []subject{{Name: "triage", Digest: map[string]string{"sha256": fmt.Sprintf("%x", sum)}}}, st.Subject)
+	assert.Equal(t, "https://gobinaries.com", st.Predicate.Builder.ID)
+	assert.Equal(t, bin, st.Predicate.Invocation.Parameters)
+	assert.Equal(t, "go1.21.5", st.Predicate.BuildConfig.Toolchain)
+	assert.Contains(t, st.Predicate.BuildConfig.Env, "GOPROXY=https://proxy.golang.org,direct")
+	assert.Equal(t, []provenanceMaterial{
+		{URI: "pkg:golang/github.com/tj/triage@v1.0.0", Digest: map[string]string{"h1": "Abcdef="}},
+		{URI: "pkg:golang/github.com/pkg/errors@v0.9.1", Digest: map[string]string{"h1": "FEBLx1zS214owpjy7qsBeixbURkuhQAwrK5UwLGTwt4="}},
+	}, st.Predicate.Materials)
+	assert.True(t, st.Predicate.Metadata.Reproducible)
+	assert.Equal(t, provenanceCompleteness{Parameters: true, Environment: true, Materials: true}, st.Predicate.Metadata.Completeness)
+	assert.Equal(t, 5*time.Second, st.Predicate.Metadata.BuildFinishedOn.Sub(st.Predicate.Metadata.BuildStartedOn))
+}
+
+// Test serving binary metadata.
+func TestServer_getInfo(t *testing.T) {
+	storage := &memoryStorage{}
+	s := &Server{
+		Templates: "../templates",
+		Storage:   storage,
+		Builder:   &buildWriter{},
+	}
+
+	query := "github.com/tj/triage/cmd/triage?os=linux&arch=amd64&version=v1.0.0"
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/info/"+query, nil))
+	assert.Equal(t, 404, w.Code)
+
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/binary/"+query, nil))
+	s.storing.wait()
+	assert.Equal(t, 200, w.Code)
+	sum := sha256.Sum256(w.Body.Bytes())
+
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/info/"+query, nil))
+	assert.Equal(t, 200, w.Code)
+
+	var meta gobinaries.Metadata
+	err := json.Unmarshal(w.Body.Bytes(), &meta)
+	assert.NoError(t, err)
+	assert.Equal(t, gobinaries.Binary{
+		Path:    "github.com/tj/triage/cmd/triage",
+		Module:  "github.com/tj/triage",
+		Version: "v1.0.0",
+		OS:      "linux",
+		Arch:    "amd64",
+	}, meta.Binary)
+	assert.Equal(t, "go1.21.5", meta.Toolchain)
+	assert.Equal(t, "-buildid=", meta.Ldflags)
+	assert.Equal(t, "h1:Abcdef=", meta.ModuleSum)
+	assert.Len(t, meta.GoSum, 4)
+	assert.Equal(t, "github.com/tj/triage v1.0.0 h1:Abcdef=", meta.GoSum[2])
+	assert.Equal(t, int64(50), meta.Size)
+	assert.Equal(t, fmt.Sprintf("%x", sum), meta.SHA256)
+	assert.False(t, meta.Built.IsZero())
+}
+
+func TestServer_private(t *testing.T) {
+	public := &memoryStorage{}
+	private := &memoryStorage{}
+	s := &Server{
+		Templates:      "../templates",
+		Storage:        public,
+		PrivateStorage: private,
+		Private:        "github.com/acme",
+		Tokens:         []string{"secret"},
+		Resolver:       &fakeResolver{},
+		Builder:        &fakeBuilder{},
+	}
+
+	get := func(s *Server, path, token string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", path, nil)
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		s.ServeHTTP(w, r)
+		s.storing.wait()
+		return w
+	}
+
+	query := "?os=linux&arch=amd64&version=v1.0.0&module=github.com%2Facme%2Ftool"
+
+	t.Run("binary without token", func(t *testing.T) {
+		for _, endpoint := range []string{"binary", "checksum", "info"} {
+			w := get(s, "/"+endpoint+"/github.com/acme/tool"+query, "")
+			assert.Equal(t, 401, w.Code, endpoint)
+			assert.Equal(t, "Bearer", w.Header().Get("WWW-Authenticate"))
+		}
+		assert.Equal(t, 0, private.creates)
+	})
+
+	t.Run("binary with invalid token", func(t *testing.T) {
+		w := get(s, "/binary/github.com/acme/tool"+query, "invalid")
+		assert.Equal(t, 403, w.Code)
+		assert.Equal(t, 0, private.creates)
+	})
+
+	t.Run("binary with token", func(t *testing.T) {
+		w := get(s, "/binary/github.com/acme/tool"+query, "secret")
+		assert.Equal(t, 200, w.Code)
+		assert.Equal(t, "github.com/acme/tool@v1.0.0 linux/amd64", w.Body.String())
+		assert.Equal(t, "private, max-age=31536000, immutable", w.Header().Get("Cache-Control"))
+		assert.Equal(t, 1, private.creates)
+		assert.Equal(t, 0, public.creates)
+
+		w = get(s, "/checksum/github.com/acme/tool"+query, "secret")
+		assert.Equal(t, 200, w.Code)
+		assert.Equal(t, "private, max-age=31536000, immutable", w.Header().Get("Cache-Control"))
+		assert.Len(t, public.sidecars, 0)
+	})
+
+	t.Run("public binary", func(t *testing.T) {
+		w := get(s, "/binary/github.com/tj/triage/cmd/triage?os=linux&arch=amd64&version=v1.0.0&module=github.com%2Ftj%2Ftriage", "")
+		assert.Equal(t, 200, w.Code)
+		assert.Equal(t, "max-age=31536000, immutable", w.Header().Get("Cache-Control"))
+		assert.Equal(t, 1, public.creates)
+	})
+
+	t.Run("script without token", func(t *testing.T) {
+		w := get(s, "/github.com/acme/tool", "")
+		assert.Equal(t, 200, w.Code)
+		assert.Contains(t, w.Body.String(), "Package is private")
+		assert.NotContains(t, w.Body.String(), "pkg=")
+	})
+
+	t.Run("script of different case without token", func(t *testing.T) {
+		w := get(s, "/github.com/ACME/tool", "")
+		assert.Equal(t, 200, w.Code)
+		assert.Contains(t, w.Body.String(), "Package is private")
+		assert.NotContains(t, w.Body.String(), "pkg=")
+
+		w = get(s, "/binary/github.com/ACME/tool?os=linux&arch=amd64&version=v1.0.0&module=github.com%2FACME%2Ftool", "")
+		assert.Equal(t, 401, w.Code)
+	})
+
+	t.Run("script of private module without token", func(t *testing.T) {
+		s := &Server{
+			Templates:      "../templates",
+			Storage:        public,
+			PrivateStorage: private,
+			Private:        "github.com/acme",
+			Tokens:         []string{"secret"},
+			Resolver: &moduleResolver{
+				modules: map[string]string{"example.com/tool": "github.com/acme/tool"},
+			},
+			Builder: &fakeBuilder{},
+		}
+
+		w := get(s, "/example.com/tool", "")
+		assert.Equal(t, 200, w.Code)
+		assert.Contains(t, w.Body.String(), "Package is private")
+		assert.NotContains(t, w.Body.String(), "pkg=")
+
+		w = get(s, "/binary/example.com/tool?os=linux&arch=amd64&version=v1.0.0", "")
+		assert.Equal(t, 401, w.Code)
+
+		w = get(s, "/example.com/tool", "secret")
+		assert.Equal(t, 200, w.Code)
+		assert.Contains(t, w.Body.String(), `&module=github.com%2Facme%2Ftool"`)
+	})
+
+	t.Run("script with token", func(t *testing.T) {
+		w := get(s, "/github.com/acme/tool", "secret")
+		assert.Equal(t, 200, w.Code)
+		assert.Contains(t, w.Body.String(), `pkg="github.com/acme/tool"`)
+		assert.Contains(t, w.Body.String(), `auth="Authorization: Bearer $GOBINARIES_TOKEN"`)
+	})
+
+	t.Run("without private storage", func(t *testing.T) {
+		s := &Server{
+			Templates: "../templates",
+			Storage:   public,
+			Private:   "github.com/acme",
+			Tokens:    []string{"secret"},
+			Builder:   &fakeBuilder{},
+		}
+
+		w := get(s, "/binary/github.com/acme/tool"+query, "secret")
+		assert.Equal(t, 403, w.Code)
+	})
+}
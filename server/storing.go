@@ -0,0 +1,77 @@
+package server
+
+import (
+	"sync"
+
+	"github.com/tj/gobinaries"
+)
+
+// storing tracks the artifacts being stored in the background, which
+// are served until they are stored, instead of building them again.
+type storing struct {
+	once  sync.Once
+	slots chan struct{}
+	mu    sync.Mutex
+	wg    sync.WaitGroup
+	arts  map[gobinaries.Binary]*artifact
+}
+
+// init initializes the maximum number of artifacts stored in the background.
+func (s *storing) init(concurrency int) {
+	s.once.Do(func() {
+		s.slots = make(chan struct{}, concurrency)
+	})
+}
+
+// acquire a background slot, returning false when every slot is in use.
+func (s *storing) acquire() bool {
+	select {
+	case s.slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// release a background slot.
+func (s *storing) release() {
+	<-s.slots
+}
+
+// add an artifact being stored for the given binary.
+func (s *storing) add(bin gobinaries.Binary, art *artifact) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.arts == nil {
+		s.arts = make(map[gobinaries.Binary]*artifact)
+	}
+	s.arts[bin] = art
+	s.wg.Add(1)
+}
+
+// done removes the artifact once it is stored.
+func (s *storing) done(bin gobinaries.Binary, art *artifact) {
+	s.mu.Lock()
+	if s.arts[bin] == art {
+		delete(s.arts, bin)
+	}
+	s.mu.Unlock()
+	s.wg.Done()
+}
+
+// get returns the artifact being stored for the given binary, or nil.
+// The artifact is retained, and must be released by the caller.
+func (s *storing) get(bin gobinaries.Binary) *artifact {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	art := s.arts[bin]
+	if art != nil {
+		art.Retain(1)
+	}
+	return art
+}
+
+// wait blocks until every artifact is stored.
+func (s *storing) wait() {
+	s.wg.Wait()
+}
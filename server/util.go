@@ -1,24 +1,89 @@
 package server
 
 import (
-	"fmt"
-	"strconv"
+	"mime"
+	"regexp"
 	"strings"
+
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
+
+	"github.com/tj/gobinaries"
+	"github.com/tj/gobinaries/resolver"
 )
 
-// getMajorVersion tries to detect the major version of the package.
-func getMajorVersion(tag string) (int, error) {
-	major := strings.Split(tag, ".")[0]
-	if len(major) < 1 {
-		return 0, fmt.Errorf("invalid major version")
+// guessPackage returns the package at the resolved version, assuming modules
+// of major version 2 and above use the "/vN" major version suffix.
+func guessPackage(mod, pkg, version string) gobinaries.Package {
+	rel := strings.Trim(strings.TrimPrefix(pkg, mod), "/")
+	major := semver.Major(version)
+
+	_, pathMajor, _ := module.SplitPathVersion(mod)
+	if pathMajor == "" && semver.IsValid(version) && module.CheckPathMajor(version, "") != nil {
+		mod += "/" + major
+		if rel == major || strings.HasPrefix(rel, major+"/") {
+			rel = strings.Trim(strings.TrimPrefix(rel, major), "/")
+		}
+	}
+
+	path := mod
+	if rel != "" {
+		path += "/" + rel
+	}
+
+	return gobinaries.Package{
+		Path:    path,
+		Module:  mod,
+		Version: version,
+	}
+}
+
+// isPackageOf returns true if the package path is within the module path.
+func isPackageOf(pkg, mod string) bool {
+	return pkg == mod || strings.HasPrefix(pkg, mod+"/")
+}
+
+// pseudoVersionCommit returns the abbreviated commit SHA of a pseudo-version,
+// or an empty string when the version is not a pseudo-version.
+func pseudoVersionCommit(version string) string {
+	m := resolver.PseudoVersionRE.FindStringSubmatch(version)
+	if m == nil {
+		return ""
 	}
-	return strconv.Atoi(major[1:])
+	return m[1]
+}
+
+// escapeMessage returns a message escaped for use within a single-quoted
+// shell string, which is output with printf as part of the format.
+func escapeMessage(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if r < ' ' || r == 0x7f {
+			return ' '
+		}
+		return r
+	}, s)
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, "%", "%%", -1)
+	s = strings.Replace(s, "'", `'\''`, -1)
+	return s
+}
+
+// escapePowerShell returns a message escaped for use within a single-quoted
+// PowerShell string, which treats typographic single quotes as quotes too.
+func escapePowerShell(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if r < ' ' || r == 0x7f {
+			return ' '
+		}
+		return r
+	}, s)
+	return strings.NewReplacer("'", "''", "\u2018", "''", "\u2019", "''", "\u201a", "''", "\u201b", "''").Replace(s)
 }
 
 // parsePackage returns package information parsed from the path.
 func parsePackage(path string) (pkg, mod, version, bin string) {
 	p := strings.Split(path, "@")
-	version = "master"
+	version = "latest"
 
 	// pkg
 	pkg = normalizePackage(p[0])
@@ -35,21 +100,62 @@ func parsePackage(path string) (pkg, mod, version, bin string) {
 	}
 
 	// binary name from pkg
-	p = strings.Split(pkg, "/")
-	bin = p[len(p)-1]
+	bin = binaryName(pkg)
 	return
 }
 
-// normalizePackage returns a normalized package, where "https://github.com/" is implied.
+// platformRE matches os and arch parameters such as "linux" and "armv7".
+var platformRE = regexp.MustCompile(`^[a-z0-9]+$`)
+
+// majorRE matches major version suffixes such as "v2".
+var majorRE = regexp.MustCompile(`^v[0-9]+$`)
+
+// binaryName returns the name of the binary built for a package, which is
+// its last path element, ignoring a major version suffix such as "/v2".
+func binaryName(pkg string) string {
+	p := strings.Split(pkg, "/")
+	if len(p) > 1 && majorRE.MatchString(p[len(p)-1]) {
+		return p[len(p)-2]
+	}
+	return p[len(p)-1]
+}
+
+// filename returns the file name of a binary, with the ".exe" extension for Windows.
+func filename(bin gobinaries.Binary) string {
+	name := binaryName(bin.Path)
+	if bin.OS == "windows" {
+		name += ".exe"
+	}
+	return name
+}
+
+// powerShell returns the path without its ".ps1" suffix, and true when a
+// PowerShell script is requested by the suffix or the Accept header field.
+func powerShell(path, accept string) (string, bool) {
+	if strings.HasSuffix(path, ".ps1") {
+		return strings.TrimSuffix(path, ".ps1"), true
+	}
+
+	for _, v := range strings.Split(accept, ",") {
+		t, _, err := mime.ParseMediaType(strings.TrimSpace(v))
+		if err == nil && (t == "application/x-powershell" || t == "text/x-powershell") {
+			return path, true
+		}
+	}
+
+	return path, false
+}
+
+// normalizePackage returns a normalized package, where "https://github.com/"
+// is implied unless the path starts with a host such as "gitlab.com".
 func normalizePackage(pkg string) string {
 	// ignore leading https://
-	pkg = strings.Replace(pkg, "https://", "", 1)
-
-	// ignore leading github.com/
-	pkg = strings.Replace(pkg, "github.com/", "", 1)
+	pkg = strings.TrimPrefix(pkg, "https://")
 
 	// implicit github.com
-	pkg = "github.com/" + pkg
+	if !strings.Contains(strings.Split(pkg, "/")[0], ".") {
+		pkg = "github.com/" + pkg
+	}
 
 	return pkg
 }
@@ -4,6 +4,8 @@ import (
 	"testing"
 
 	"github.com/tj/assert"
+
+	"github.com/tj/gobinaries"
 )
 
 // Test parsing package paths with top-level commands.
@@ -20,7 +22,7 @@ func TestParsePackage_root(t *testing.T) {
 		pkg, mod, version, bin := parsePackage("https://github.com/tj/letterbox")
 		assert.Equal(t, "github.com/tj/letterbox", pkg)
 		assert.Equal(t, "github.com/tj/letterbox", mod)
-		assert.Equal(t, "master", version)
+		assert.Equal(t, "latest", version)
 		assert.Equal(t, "letterbox", bin)
 	})
 
@@ -28,7 +30,7 @@ func TestParsePackage_root(t *testing.T) {
 		pkg, mod, version, bin := parsePackage("github.com/tj/letterbox")
 		assert.Equal(t, "github.com/tj/letterbox", pkg)
 		assert.Equal(t, "github.com/tj/letterbox", mod)
-		assert.Equal(t, "master", version)
+		assert.Equal(t, "latest", version)
 		assert.Equal(t, "letterbox", bin)
 	})
 
@@ -36,7 +38,7 @@ func TestParsePackage_root(t *testing.T) {
 		pkg, mod, version, bin := parsePackage("tj/letterbox")
 		assert.Equal(t, "github.com/tj/letterbox", pkg)
 		assert.Equal(t, "github.com/tj/letterbox", mod)
-		assert.Equal(t, "master", version)
+		assert.Equal(t, "latest", version)
 		assert.Equal(t, "letterbox", bin)
 	})
 }
@@ -55,7 +57,7 @@ func TestParsePackage_nested(t *testing.T) {
 		pkg, mod, version, bin := parsePackage("https://github.com/tj/staticgen/cmd/staticgen")
 		assert.Equal(t, "github.com/tj/staticgen/cmd/staticgen", pkg)
 		assert.Equal(t, "github.com/tj/staticgen", mod)
-		assert.Equal(t, "master", version)
+		assert.Equal(t, "latest", version)
 		assert.Equal(t, "staticgen", bin)
 	})
 
@@ -63,7 +65,7 @@ func TestParsePackage_nested(t *testing.T) {
 		pkg, mod, version, bin := parsePackage("github.com/tj/staticgen/cmd/staticgen")
 		assert.Equal(t, "github.com/tj/staticgen/cmd/staticgen", pkg)
 		assert.Equal(t, "github.com/tj/staticgen", mod)
-		assert.Equal(t, "master", version)
+		assert.Equal(t, "latest", version)
 		assert.Equal(t, "staticgen", bin)
 	})
 
@@ -71,7 +73,137 @@ func TestParsePackage_nested(t *testing.T) {
 		pkg, mod, version, bin := parsePackage("tj/staticgen/cmd/staticgen")
 		assert.Equal(t, "github.com/tj/staticgen/cmd/staticgen", pkg)
 		assert.Equal(t, "github.com/tj/staticgen", mod)
-		assert.Equal(t, "master", version)
+		assert.Equal(t, "latest", version)
 		assert.Equal(t, "staticgen", bin)
 	})
 }
+
+// Test parsing commits from pseudo-versions.
+func TestPseudoVersionCommit(t *testing.T) {
+	assert.Equal(t, "abcdef123456", pseudoVersionCommit("v0.0.0-20200101120000-abcdef123456"))
+	assert.Equal(t, "abcdef123456", pseudoVersionCommit("v2.0.0-20200101120000-abcdef123456"))
+	assert.Equal(t, "abcdef123456", pseudoVersionCommit("v1.2.4-0.20200101120000-abcdef123456"))
+	assert.Equal(t, "abcdef123456", pseudoVersionCommit("v1.2.4-rc.1.0.20200101120000-abcdef123456"))
+	assert.Equal(t, "", pseudoVersionCommit("v1.2.3"))
+	assert.Equal(t, "", pseudoVersionCommit("v1.2.3-rc.1"))
+}
+
+// Test parsing package paths of other hosts.
+func TestParsePackage_hosts(t *testing.T) {
+	t.Run("gitlab.com", func(t *testing.T) {
+		pkg, mod, version, bin := parsePackage("gitlab.com/tj/tool/cmd/tool@v1.2.0")
+		assert.Equal(t, "gitlab.com/tj/tool/cmd/tool", pkg)
+		assert.Equal(t, "gitlab.com/tj/tool", mod)
+		assert.Equal(t, "v1.2.0", version)
+		assert.Equal(t, "tool", bin)
+	})
+
+	t.Run("with https://", func(t *testing.T) {
+		pkg, mod, version, bin := parsePackage("https://gitea.example.com/tj/tool")
+		assert.Equal(t, "gitea.example.com/tj/tool", pkg)
+		assert.Equal(t, "gitea.example.com/tj/tool", mod)
+		assert.Equal(t, "latest", version)
+		assert.Equal(t, "tool", bin)
+	})
+
+	t.Run("vanity", func(t *testing.T) {
+		pkg, _, version, bin := parsePackage("golang.org/x/tools/cmd/stringer@latest")
+		assert.Equal(t, "golang.org/x/tools/cmd/stringer", pkg)
+		assert.Equal(t, "latest", version)
+		assert.Equal(t, "stringer", bin)
+	})
+}
+
+// Test escaping messages for the install script.
+func TestEscapeMessage(t *testing.T) {
+	assert.Equal(t, "use v2 instead", escapeMessage("use v2 instead"))
+	assert.Equal(t, `it'\''s $(rm -rf /) 100%% \\n done`, escapeMessage("it's $(rm -rf /) 100% \\n\ndone"))
+}
+
+// Test escaping messages for the PowerShell install script.
+func TestEscapePowerShell(t *testing.T) {
+	assert.Equal(t, "use v2 instead", escapePowerShell("use v2 instead"))
+	assert.Equal(t, "it''s $(Remove-Item) ''quoted'' done", escapePowerShell("it's $(Remove-Item) \u2018quoted\u2019\ndone"))
+}
+
+// Test binary names.
+func TestBinaryName(t *testing.T) {
+	assert.Equal(t, "triage", binaryName("github.com/tj/triage/cmd/triage"))
+	assert.Equal(t, "triage", binaryName("github.com/tj/triage/v2"))
+	assert.Equal(t, "triage", binaryName("github.com/tj/triage"))
+	assert.Equal(t, "v2", binaryName("v2"))
+}
+
+// Test binary file names.
+func TestFilename(t *testing.T) {
+	bin := gobinaries.Binary{Path: "github.com/tj/triage/v2/cmd/triage", OS: "linux"}
+	assert.Equal(t, "triage", filename(bin))
+	bin.OS = "windows"
+	assert.Equal(t, "triage.exe", filename(bin))
+}
+
+// Test detecting PowerShell script requests.
+func TestPowerShell(t *testing.T) {
+	cases := []struct {
+		path     string
+		accept   string
+		expected string
+		ps       bool
+	}{
+		{"tj/triage", "", "tj/triage", false},
+		{"tj/triage", "*/*", "tj/triage", false},
+		{"tj/triage.ps1", "", "tj/triage", true},
+		{"tj/triage@v1.0.0.ps1", "", "tj/triage@v1.0.0", true},
+		{"tj/triage", "application/x-powershell", "tj/triage", true},
+		{"tj/triage", "text/html, text/x-powershell;q=0.9", "tj/triage", true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.path+" "+c.accept, func(t *testing.T) {
+			path, ps := powerShell(c.path, c.accept)
+			assert.Equal(t, c.expected, path)
+			assert.Equal(t, c.ps, ps)
+		})
+	}
+}
+
+// Test guessing packages without go.mod information.
+func TestGuessPackage(t *testing.T) {
+	cases := []struct {
+		pkg      string
+		version  string
+		expected gobinaries.Package
+	}{
+		{"github.com/tj/triage/cmd/triage", "v1.2.0", gobinaries.Package{
+			Path:    "github.com/tj/triage/cmd/triage",
+			Module:  "github.com/tj/triage",
+			Version: "v1.2.0",
+		}},
+		{"github.com/tj/triage", "v2.1.0", gobinaries.Package{
+			Path:    "github.com/tj/triage/v2",
+			Module:  "github.com/tj/triage/v2",
+			Version: "v2.1.0",
+		}},
+		{"github.com/tj/triage/cmd/triage", "v2.1.0", gobinaries.Package{
+			Path:    "github.com/tj/triage/v2/cmd/triage",
+			Module:  "github.com/tj/triage/v2",
+			Version: "v2.1.0",
+		}},
+		{"github.com/tj/triage/v2/cmd/triage", "v2.1.0", gobinaries.Package{
+			Path:    "github.com/tj/triage/v2/cmd/triage",
+			Module:  "github.com/tj/triage/v2",
+			Version: "v2.1.0",
+		}},
+		{"github.com/tj/triage/cmd/triage", "v2.0.0+incompatible", gobinaries.Package{
+			Path:    "github.com/tj/triage/cmd/triage",
+			Module:  "github.com/tj/triage",
+			Version: "v2.0.0+incompatible",
+		}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.pkg+"@"+c.version, func(t *testing.T) {
+			assert.Equal(t, c.expected, guessPackage("github.com/tj/triage", c.pkg, c.version))
+		})
+	}
+}
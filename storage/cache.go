@@ -0,0 +1,362 @@
+package storage
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/tj/gobinaries"
+)
+
+// Cache is a storage wrapper keeping the most recently used objects of
+// a backing storage in memory, or on local disk, within a size budget.
+// Objects are cached when they are fetched from or created in the
+// backing storage.
+type Cache struct {
+	// Storage is the backing storage.
+	Storage gobinaries.Storage
+
+	// MaxSize is the size budget in bytes.
+	MaxSize int64
+
+	// Dir is an optional directory used to cache objects on disk,
+	// otherwise objects are cached in memory. Objects are cached
+	// within its "objects" sub-directory, which is cleared when the
+	// cache is initialized, so it must not be shared by caches.
+	Dir string
+
+	once    sync.Once
+	initErr error
+	dir     string
+
+	mu          sync.Mutex
+	lru         *list.List
+	objects     map[gobinaries.Binary]*list.Element
+	size        int64
+	pendingSize int64
+	hits        int64
+	misses      int64
+}
+
+// CacheStats represents cache statistics.
+type CacheStats struct {
+	// Hits is the number of objects served from the cache.
+	Hits int64
+
+	// Misses is the number of objects fetched from the backing storage.
+	Misses int64
+
+	// Objects is the number of objects cached.
+	Objects int
+
+	// Size is the size of the objects cached in bytes.
+	Size int64
+}
+
+// cached is a cached object.
+type cached struct {
+	bin  gobinaries.Binary
+	size int64
+	body []byte
+	path string
+}
+
+// Create an object in the backing storage, caching it on success.
+func (c *Cache) Create(ctx context.Context, r io.Reader, bin gobinaries.Binary) error {
+	p := c.pending(bin)
+
+	// pass the length through, so that it is not spooled to find it
+	tee := io.TeeReader(r, p)
+	if size, err := contentLength(r); err == nil {
+		tee = &sizedReader{Reader: tee, size: size}
+	}
+
+	err := c.Storage.Create(ctx, tee, bin)
+	if err != nil {
+		p.Discard()
+		return err
+	}
+
+	p.Commit()
+	return nil
+}
+
+// Get returns an object from the cache, or from the backing storage,
+// in which case it is cached once it has been read completely.
+func (c *Cache) Get(ctx context.Context, bin gobinaries.Binary) (io.ReadCloser, error) {
+	c.mu.Lock()
+	c.init()
+
+	if e, ok := c.objects[bin]; ok {
+		r, err := e.Value.(*cached).open()
+		if err == nil {
+			c.lru.MoveToFront(e)
+			c.hits++
+			c.mu.Unlock()
+			return r, nil
+		}
+
+		// fall back to the backing storage
+		c.remove(e)
+	}
+
+	c.misses++
+	c.mu.Unlock()
+
+	r, err := c.Storage.Get(ctx, bin)
+	if err != nil {
+		return nil, err
+	}
+
+	return &cacheReader{
+		ReadCloser: r,
+		pending:    c.pending(bin),
+	}, nil
+}
+
+// CreateSidecar creates a sidecar object in the backing storage, sidecars are not cached.
+func (c *Cache) CreateSidecar(ctx context.Context, r io.Reader, bin gobinaries.Binary, name string) error {
+	return c.Storage.CreateSidecar(ctx, r, bin, name)
+}
+
+// GetSidecar returns a sidecar object from the backing storage.
+func (c *Cache) GetSidecar(ctx context.Context, bin gobinaries.Binary, name string) (io.ReadCloser, error) {
+	return c.Storage.GetSidecar(ctx, bin, name)
+}
+
+// Stats returns cache statistics.
+func (c *Cache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.init()
+	return CacheStats{
+		Hits:    c.hits,
+		Misses:  c.misses,
+		Objects: c.lru.Len(),
+		Size:    c.size,
+	}
+}
+
+// init initializes the cache, the caller must hold the lock.
+func (c *Cache) init() {
+	c.once.Do(func() {
+		c.lru = list.New()
+		c.objects = make(map[gobinaries.Binary]*list.Element)
+		if c.Dir != "" {
+			c.dir = filepath.Join(c.Dir, "objects")
+			c.initErr = clearDir(c.Dir, "objects")
+			if c.initErr == nil {
+				c.initErr = os.MkdirAll(c.dir, 0755)
+			}
+		}
+	})
+}
+
+// clearDir removes the entries of dir matching the pattern, such as the objects
+// left behind by a previous process, which are not indexed by the cache.
+func clearDir(dir, pattern string) error {
+	paths, err := filepath.Glob(filepath.Join(dir, pattern+"*"))
+	if err != nil {
+		return err
+	}
+
+	for _, path := range paths {
+		err := os.RemoveAll(path)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// reserve reserves n bytes for a pending object, returning false when the
+// objects pending would exceed the size budget, bounding their size to the
+// budget regardless of the number of objects being read concurrently.
+func (c *Cache) reserve(n int64) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.pendingSize+n > c.MaxSize {
+		return false
+	}
+
+	c.pendingSize += n
+	return true
+}
+
+// release releases n bytes reserved for a pending object.
+func (c *Cache) release(n int64) {
+	c.mu.Lock()
+	c.pendingSize -= n
+	c.mu.Unlock()
+}
+
+// pending returns a new pending cache object.
+func (c *Cache) pending(bin gobinaries.Binary) *pendingObject {
+	c.mu.Lock()
+	c.init()
+	dir, err := c.dir, c.initErr
+	c.mu.Unlock()
+
+	p := &pendingObject{
+		cache: c,
+		bin:   bin,
+		err:   err,
+	}
+
+	if err == nil && dir != "" {
+		p.file, p.err = ioutil.TempFile(dir, "object")
+	}
+
+	return p
+}
+
+// add an object to the cache, evicting the least recently used objects as necessary.
+func (c *Cache) add(obj *cached) {
+	c.mu.Lock()
+	var evicted []*cached
+
+	if e, ok := c.objects[obj.bin]; ok {
+		evicted = append(evicted, e.Value.(*cached))
+		c.remove(e)
+	}
+
+	for c.size+obj.size > c.MaxSize && c.lru.Len() > 0 {
+		e := c.lru.Back()
+		evicted = append(evicted, e.Value.(*cached))
+		c.remove(e)
+	}
+
+	c.objects[obj.bin] = c.lru.PushFront(obj)
+	c.size += obj.size
+	c.mu.Unlock()
+
+	for _, old := range evicted {
+		if old.path != "" && old.path != obj.path {
+			os.Remove(old.path)
+		}
+	}
+}
+
+// remove an object from the cache, the caller must hold the lock.
+func (c *Cache) remove(e *list.Element) {
+	obj := e.Value.(*cached)
+	c.lru.Remove(e)
+	delete(c.objects, obj.bin)
+	c.size -= obj.size
+}
+
+// open returns a reader for the cached object.
+func (obj *cached) open() (io.ReadCloser, error) {
+	if obj.path == "" {
+		return ioutil.NopCloser(bytes.NewReader(obj.body)), nil
+	}
+	return os.Open(obj.path)
+}
+
+// pendingObject is an object being written to the cache. Writes never fail,
+// instead the object is discarded when it exceeds the budget, the objects
+// pending exceed the budget, or an error occurs, and is then only streamed.
+type pendingObject struct {
+	cache *Cache
+	bin   gobinaries.Binary
+	buf   bytes.Buffer
+	file  *os.File
+	size  int64
+	err   error
+}
+
+// Write implementation.
+func (p *pendingObject) Write(b []byte) (int, error) {
+	if p.err != nil {
+		return len(b), nil
+	}
+
+	if !p.cache.reserve(int64(len(b))) {
+		p.err = io.ErrShortBuffer
+		p.Discard()
+		return len(b), nil
+	}
+	p.size += int64(len(b))
+
+	if p.file != nil {
+		_, p.err = p.file.Write(b)
+	} else {
+		p.buf.Write(b)
+	}
+
+	return len(b), nil
+}
+
+// Commit adds the object to the cache.
+func (p *pendingObject) Commit() {
+	if p.err != nil {
+		p.Discard()
+		return
+	}
+
+	obj := &cached{
+		bin:  p.bin,
+		size: p.size,
+	}
+
+	if p.file != nil {
+		if err := p.file.Close(); err != nil {
+			p.Discard()
+			return
+		}
+		obj.path = p.file.Name()
+	} else {
+		obj.body = p.buf.Bytes()
+	}
+
+	p.cache.add(obj)
+	p.cache.release(p.size)
+	p.size = 0
+}
+
+// Discard the object, releasing the bytes reserved.
+func (p *pendingObject) Discard() {
+	p.cache.release(p.size)
+	p.size = 0
+	p.buf = bytes.Buffer{}
+
+	if p.file != nil {
+		p.file.Close()
+		os.Remove(p.file.Name())
+		p.file = nil
+	}
+}
+
+// cacheReader caches an object as it is read from the backing storage.
+type cacheReader struct {
+	io.ReadCloser
+	pending *pendingObject
+	eof     bool
+}
+
+// Read implementation.
+func (r *cacheReader) Read(b []byte) (int, error) {
+	n, err := r.ReadCloser.Read(b)
+	r.pending.Write(b[:n])
+	if err == io.EOF {
+		r.eof = true
+	}
+	return n, err
+}
+
+// Close implementation, caching the object when it was read completely.
+func (r *cacheReader) Close() error {
+	if r.eof {
+		r.pending.Commit()
+	} else {
+		r.pending.Discard()
+	}
+	return r.ReadCloser.Close()
+}
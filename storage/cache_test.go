@@ -0,0 +1,183 @@
+package storage_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/tj/assert"
+
+	"github.com/tj/gobinaries"
+	"github.com/tj/gobinaries/storage"
+)
+
+// read returns the contents of an object.
+func read(t testing.TB, s gobinaries.Storage, bin gobinaries.Binary) string {
+	r, err := s.Get(context.Background(), bin)
+	assert.NoError(t, err)
+	defer r.Close()
+
+	b, err := ioutil.ReadAll(r)
+	assert.NoError(t, err)
+	return string(b)
+}
+
+// binary returns a binary for the given version.
+func binary(version string) gobinaries.Binary {
+	return gobinaries.Binary{
+		Path:    "github.com/tj/node-prune",
+		Version: version,
+		OS:      "darwin",
+		Arch:    "amd64",
+	}
+}
+
+// Test caching objects.
+func TestCache(t *testing.T) {
+	for _, name := range []string{"memory", "disk"} {
+		t.Run(name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "gobinaries-cache")
+			assert.NoError(t, err)
+			defer os.RemoveAll(dir)
+
+			backing := &storage.Filesystem{
+				Dir: dir + "/backing",
+			}
+
+			c := &storage.Cache{
+				Storage: backing,
+				MaxSize: 10,
+			}
+
+			if name == "disk" {
+				c.Dir = dir + "/cache"
+			}
+
+			ctx := context.Background()
+
+			t.Run("populated on create", func(t *testing.T) {
+				err := c.Create(ctx, strings.NewReader("v1"), binary("v1.0.0"))
+				assert.NoError(t, err)
+				assert.Equal(t, "v1", read(t, c, binary("v1.0.0")))
+				assert.Equal(t, storage.CacheStats{Hits: 1, Objects: 1, Size: 2}, c.Stats())
+			})
+
+			t.Run("populated on get", func(t *testing.T) {
+				err := backing.Create(ctx, strings.NewReader("v2"), binary("v2.0.0"))
+				assert.NoError(t, err)
+				assert.Equal(t, "v2", read(t, c, binary("v2.0.0")))
+				assert.Equal(t, "v2", read(t, c, binary("v2.0.0")))
+				assert.Equal(t, storage.CacheStats{Hits: 2, Misses: 1, Objects: 2, Size: 4}, c.Stats())
+			})
+
+			t.Run("missing", func(t *testing.T) {
+				_, err := c.Get(ctx, binary("v9.0.0"))
+				assert.Equal(t, gobinaries.ErrObjectNotFound, err)
+			})
+
+			t.Run("evicts least recently used", func(t *testing.T) {
+				// touch v1 so that v2 is least recently used
+				assert.Equal(t, "v1", read(t, c, binary("v1.0.0")))
+
+				err := c.Create(ctx, strings.NewReader("version3"), binary("v3.0.0"))
+				assert.NoError(t, err)
+
+				stats := c.Stats()
+				assert.Equal(t, 2, stats.Objects)
+				assert.Equal(t, int64(10), stats.Size)
+
+				misses := stats.Misses
+				assert.Equal(t, "v1", read(t, c, binary("v1.0.0")))
+				assert.Equal(t, "version3", read(t, c, binary("v3.0.0")))
+				assert.Equal(t, misses, c.Stats().Misses)
+
+				assert.Equal(t, "v2", read(t, c, binary("v2.0.0")))
+				assert.Equal(t, misses+1, c.Stats().Misses)
+			})
+
+			t.Run("ignores objects exceeding the budget", func(t *testing.T) {
+				err := c.Create(ctx, strings.NewReader("a large binary"), binary("v4.0.0"))
+				assert.NoError(t, err)
+				assert.Equal(t, "a large binary", read(t, c, binary("v4.0.0")))
+				assert.Equal(t, "a large binary", read(t, c, binary("v4.0.0")))
+				assert.True(t, c.Stats().Size <= 10)
+			})
+		})
+	}
+}
+
+// Test that the objects pending are bounded by the budget.
+func TestCache_pending(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gobinaries-cache")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	backing := &storage.Filesystem{
+		Dir: dir,
+	}
+
+	c := &storage.Cache{
+		Storage: backing,
+		MaxSize: 10,
+	}
+
+	ctx := context.Background()
+	assert.NoError(t, backing.Create(ctx, strings.NewReader("binary"), binary("v1.0.0")))
+	assert.NoError(t, backing.Create(ctx, strings.NewReader("binary"), binary("v2.0.0")))
+
+	a, err := c.Get(ctx, binary("v1.0.0"))
+	assert.NoError(t, err)
+	b, err := c.Get(ctx, binary("v2.0.0"))
+	assert.NoError(t, err)
+
+	// both are streamed, only the first is cached
+	ab, err := ioutil.ReadAll(a)
+	assert.NoError(t, err)
+	bb, err := ioutil.ReadAll(b)
+	assert.NoError(t, err)
+	assert.NoError(t, a.Close())
+	assert.NoError(t, b.Close())
+
+	assert.Equal(t, "binary", string(ab))
+	assert.Equal(t, "binary", string(bb))
+	assert.Equal(t, 1, c.Stats().Objects)
+
+	// released once read
+	assert.Equal(t, "binary", read(t, c, binary("v2.0.0")))
+	assert.Equal(t, "binary", read(t, c, binary("v2.0.0")))
+	assert.Equal(t, int64(3), c.Stats().Misses)
+}
+
+// Test that objects left on disk by previous caches are removed.
+func TestCache_dir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gobinaries-cache")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "cache", "objects"), 0755))
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "cache", "objects123"), 0755))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "cache", "objects", "object1"), []byte("stale"), 0644))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "cache", "objects123", "object1"), []byte("stale"), 0644))
+
+	c := &storage.Cache{
+		Storage: &storage.Filesystem{Dir: filepath.Join(dir, "backing")},
+		MaxSize: 10,
+		Dir:     filepath.Join(dir, "cache"),
+	}
+
+	ctx := context.Background()
+	assert.NoError(t, c.Create(ctx, strings.NewReader("v1"), binary("v1.0.0")))
+
+	entries, err := ioutil.ReadDir(filepath.Join(dir, "cache"))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(entries))
+	assert.Equal(t, "objects", entries[0].Name())
+
+	objects, err := ioutil.ReadDir(filepath.Join(dir, "cache", "objects"))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(objects))
+	assert.Equal(t, "v1", read(t, c, binary("v1.0.0")))
+}
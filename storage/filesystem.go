@@ -0,0 +1,121 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/tj/gobinaries"
+)
+
+// Filesystem is a local filesystem object store for binaries.
+type Filesystem struct {
+	// Dir is the root directory.
+	Dir string
+
+	// Prefix is an optional object key prefix.
+	Prefix string
+}
+
+// Create an object representing the package's binary. The object is written
+// to a temporary file and renamed, so partial objects are never visible.
+func (f *Filesystem) Create(ctx context.Context, r io.Reader, bin gobinaries.Binary) error {
+	return f.create(r, getKey(f.Prefix, bin))
+}
+
+// Get returns an object.
+func (f *Filesystem) Get(ctx context.Context, bin gobinaries.Binary) (io.ReadCloser, error) {
+	return f.get(getKey(f.Prefix, bin))
+}
+
+// CreateSidecar creates a sidecar object of the package's binary.
+func (f *Filesystem) CreateSidecar(ctx context.Context, r io.Reader, bin gobinaries.Binary, name string) error {
+	return f.create(r, getSidecarKey(f.Prefix, bin, name))
+}
+
+// GetSidecar returns a sidecar object.
+func (f *Filesystem) GetSidecar(ctx context.Context, bin gobinaries.Binary, name string) (io.ReadCloser, error) {
+	return f.get(getSidecarKey(f.Prefix, bin, name))
+}
+
+// create writes the object of the given key.
+func (f *Filesystem) create(r io.Reader, key string) error {
+	path, err := f.getPath(key)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(path)
+
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return fmt.Errorf("creating directory: %w", normalizeFileError(err))
+	}
+
+	tmp, err := ioutil.TempFile(dir, ".tmp-")
+	if err != nil {
+		return fmt.Errorf("creating tempfile: %w", normalizeFileError(err))
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = io.Copy(tmp, r)
+	if err != nil {
+		tmp.Close()
+		return fmt.Errorf("copying: %w", err)
+	}
+
+	err = tmp.Close()
+	if err != nil {
+		return fmt.Errorf("closing: %w", err)
+	}
+
+	err = os.Rename(tmp.Name(), path)
+	if err != nil {
+		return fmt.Errorf("renaming: %w", normalizeFileError(err))
+	}
+
+	return nil
+}
+
+// get opens the object of the given key.
+func (f *Filesystem) get(key string) (io.ReadCloser, error) {
+	path, err := f.getPath(key)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := os.Open(path)
+	if err != nil {
+		return nil, normalizeFileError(err)
+	}
+
+	return r, nil
+}
+
+// getPath returns the object path of the given key, refusing
+// keys such as "../file" which resolve to a path outside of Dir.
+func (f *Filesystem) getPath(key string) (string, error) {
+	path := filepath.Join(f.Dir, filepath.FromSlash(key))
+
+	rel, err := filepath.Rel(filepath.Clean(f.Dir), path)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("invalid key %q", key)
+	}
+
+	return path, nil
+}
+
+// normalizeFileError returns a typed error for missing files and permission failures.
+func normalizeFileError(err error) error {
+	switch {
+	case os.IsNotExist(err):
+		return gobinaries.ErrObjectNotFound
+	case os.IsPermission(err):
+		return fmt.Errorf("%w: %s", gobinaries.ErrStorageForbidden, err)
+	default:
+		return err
+	}
+}
@@ -0,0 +1,135 @@
+package storage_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/tj/assert"
+
+	"github.com/tj/gobinaries"
+	"github.com/tj/gobinaries/storage"
+)
+
+// Test filesystem storage.
+func TestFilesystem(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gobinaries-storage")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	s := &storage.Filesystem{
+		Dir:    dir,
+		Prefix: "testing",
+	}
+
+	ctx := context.Background()
+
+	bin := gobinaries.Binary{
+		Path:    "github.com/tj/node-prune",
+		Version: "v1.0.0",
+		OS:      "darwin",
+		Arch:    "amd64",
+	}
+
+	t.Run("missing", func(t *testing.T) {
+		_, err := s.Get(ctx, bin)
+		assert.Equal(t, gobinaries.ErrObjectNotFound, err)
+	})
+
+	t.Run("create", func(t *testing.T) {
+		err := s.Create(ctx, strings.NewReader("Hello World"), bin)
+		assert.NoError(t, err)
+
+		path := filepath.Join(dir, "testing", "github.com-tj-node-prune", "v1.0.0-darwin-amd64")
+		b, err := ioutil.ReadFile(path)
+		assert.NoError(t, err)
+		assert.Equal(t, "Hello World", string(b))
+
+		files, err := ioutil.ReadDir(filepath.Dir(path))
+		assert.NoError(t, err)
+		assert.Len(t, files, 1, "temporary files should be removed")
+	})
+
+	t.Run("get", func(t *testing.T) {
+		r, err := s.Get(ctx, bin)
+		assert.NoError(t, err)
+		defer r.Close()
+
+		b, err := ioutil.ReadAll(r)
+		assert.NoError(t, err)
+		assert.Equal(t, "Hello World", string(b))
+	})
+
+	t.Run("overwrite", func(t *testing.T) {
+		err := s.Create(ctx, strings.NewReader("Hello Again"), bin)
+		assert.NoError(t, err)
+
+		r, err := s.Get(ctx, bin)
+		assert.NoError(t, err)
+		defer r.Close()
+
+		b, err := ioutil.ReadAll(r)
+		assert.NoError(t, err)
+		assert.Equal(t, "Hello Again", string(b))
+	})
+
+	t.Run("sidecar", func(t *testing.T) {
+		_, err := s.GetSidecar(ctx, bin, "sha256")
+		assert.Equal(t, gobinaries.ErrObjectNotFound, err)
+
+		err = s.CreateSidecar(ctx, strings.NewReader("abc123"), bin, "sha256")
+		assert.NoError(t, err)
+
+		path := filepath.Join(dir, "testing", "github.com-tj-node-prune", "v1.0.0-darwin-amd64.sha256")
+		b, err := ioutil.ReadFile(path)
+		assert.NoError(t, err)
+		assert.Equal(t, "abc123", string(b))
+
+		r, err := s.GetSidecar(ctx, bin, "sha256")
+		assert.NoError(t, err)
+		defer r.Close()
+
+		b, err = ioutil.ReadAll(r)
+		assert.NoError(t, err)
+		assert.Equal(t, "abc123", string(b))
+	})
+}
+
+func TestFilesystem_traversal(t *testing.T) {
+	root, err := ioutil.TempDir("", "gobinaries-storage")
+	assert.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	dir := filepath.Join(root, "storage")
+	err = os.Mkdir(dir, 0755)
+	assert.NoError(t, err)
+
+	err = ioutil.WriteFile(filepath.Join(root, "leak-linux-amd64"), []byte("secret"), 0644)
+	assert.NoError(t, err)
+
+	s := &storage.Filesystem{
+		Dir:    dir,
+		Prefix: "testing",
+	}
+
+	ctx := context.Background()
+
+	bin := gobinaries.Binary{
+		Path:    "github.com/tj/x",
+		Version: "../../../leak",
+		OS:      "linux",
+		Arch:    "amd64",
+	}
+
+	_, err = s.Get(ctx, bin)
+	assert.Error(t, err)
+
+	err = s.CreateSidecar(ctx, strings.NewReader("abc123"), bin, "sha256")
+	assert.Error(t, err)
+
+	_, err = os.Stat(filepath.Join(root, "leak-linux-amd64.sha256"))
+	assert.True(t, os.IsNotExist(err))
+}
@@ -5,9 +5,11 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"strings"
+	"net"
+	"net/http"
 
 	"cloud.google.com/go/storage"
+	"google.golang.org/api/googleapi"
 
 	"github.com/tj/gobinaries"
 )
@@ -29,45 +31,78 @@ type Google struct {
 
 // Create an object representing the package's binary.
 func (g *Google) Create(ctx context.Context, r io.Reader, bin gobinaries.Binary) error {
-	key := g.getKey(bin)
+	return g.create(ctx, r, getKey(g.Prefix, bin))
+}
+
+// Get returns an object.
+func (g *Google) Get(ctx context.Context, bin gobinaries.Binary) (io.ReadCloser, error) {
+	return g.get(ctx, getKey(g.Prefix, bin))
+}
 
+// CreateSidecar creates a sidecar object of the package's binary.
+func (g *Google) CreateSidecar(ctx context.Context, r io.Reader, bin gobinaries.Binary, name string) error {
+	return g.create(ctx, r, getSidecarKey(g.Prefix, bin, name))
+}
+
+// GetSidecar returns a sidecar object.
+func (g *Google) GetSidecar(ctx context.Context, bin gobinaries.Binary, name string) (io.ReadCloser, error) {
+	return g.get(ctx, getSidecarKey(g.Prefix, bin, name))
+}
+
+// create writes the object of the given key.
+func (g *Google) create(ctx context.Context, r io.Reader, key string) error {
 	obj := g.Client.Bucket(g.Bucket).Object(key)
 	dst := obj.NewWriter(ctx)
 
 	_, err := io.Copy(dst, r)
 	if err != nil {
-		return fmt.Errorf("copying: %w", err)
+		return fmt.Errorf("copying: %w", normalizeError(err))
 	}
 
 	err = dst.Close()
 	if err != nil {
-		return fmt.Errorf("closing: %w", err)
+		return fmt.Errorf("closing: %w", normalizeError(err))
 	}
 
 	return nil
 }
 
-// Get returns an object.
-func (g *Google) Get(ctx context.Context, bin gobinaries.Binary) (io.ReadCloser, error) {
-	key := g.getKey(bin)
+// get opens the object of the given key.
+func (g *Google) get(ctx context.Context, key string) (io.ReadCloser, error) {
 	obj := g.Client.Bucket(g.Bucket).Object(key)
 	r, err := obj.NewReader(ctx)
-
-	if isNotExists(err) {
-		return nil, gobinaries.ErrObjectNotFound
+	if err != nil {
+		return nil, normalizeError(err)
 	}
 
 	return r, nil
 }
 
-// getKey returns the object key in the form `<pkg>/<binary>`.
-func (g *Google) getKey(bin gobinaries.Binary) string {
-	dir := g.Prefix + "/" + strings.Replace(bin.Path, "/", "-", -1)
-	file := fmt.Sprintf("%s-%s-%s", bin.Version, bin.OS, bin.Arch)
-	return dir + "/" + file
-}
+// normalizeError returns a typed error for missing objects, transient
+// failures and permission failures, or err when it is unknown.
+func normalizeError(err error) error {
+	if err == storage.ErrObjectNotExist {
+		return gobinaries.ErrObjectNotFound
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return fmt.Errorf("%w: %s", gobinaries.ErrStorageUnavailable, err)
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return fmt.Errorf("%w: %s", gobinaries.ErrStorageUnavailable, err)
+	}
+
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		switch {
+		case apiErr.Code == http.StatusUnauthorized || apiErr.Code == http.StatusForbidden:
+			return fmt.Errorf("%w: %s", gobinaries.ErrStorageForbidden, err)
+		case apiErr.Code == http.StatusTooManyRequests || apiErr.Code >= 500:
+			return fmt.Errorf("%w: %s", gobinaries.ErrStorageUnavailable, err)
+		}
+	}
 
-// isNotExists returns true if the err is present and represents a missing Cloud Storage object.
-func isNotExists(err error) bool {
-	return err != nil && err.Error() == "storage: object doesn't exist"
+	return err
 }
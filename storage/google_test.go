@@ -2,13 +2,18 @@ package storage_test
 
 import (
 	"context"
+	"errors"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"strings"
 	"testing"
+	"time"
 
 	googlestorage "cloud.google.com/go/storage"
 	"github.com/tj/assert"
+	"google.golang.org/api/option"
 
 	"github.com/tj/gobinaries"
 	"github.com/tj/gobinaries/storage"
@@ -79,6 +84,53 @@ func TestGoogle_Get(t *testing.T) {
 	})
 }
 
+// Test fetching objects when Cloud Storage fails.
+func TestGoogle_Get_errors(t *testing.T) {
+	cases := []struct {
+		name   string
+		status int
+		err    error
+	}{
+		{"not found", http.StatusNotFound, gobinaries.ErrObjectNotFound},
+		{"unauthorized", http.StatusUnauthorized, gobinaries.ErrStorageForbidden},
+		{"forbidden", http.StatusForbidden, gobinaries.ErrStorageForbidden},
+		{"unavailable", http.StatusServiceUnavailable, gobinaries.ErrStorageUnavailable},
+		{"rate limited", http.StatusTooManyRequests, gobinaries.ErrStorageUnavailable},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(c.status)
+			}))
+			defer srv.Close()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+			defer cancel()
+
+			client, err := googlestorage.NewClient(ctx,
+				option.WithEndpoint(srv.URL+"/storage/v1/"),
+				option.WithHTTPClient(srv.Client()))
+			assert.NoError(t, err)
+
+			s := &storage.Google{
+				Client: client,
+				Bucket: "gobinaries",
+				Prefix: "testing",
+			}
+
+			_, err = s.Get(ctx, gobinaries.Binary{
+				Path:    "github.com/tj/node-prune",
+				Version: "v1.0.0",
+				OS:      "darwin",
+				Arch:    "amd64",
+			})
+
+			assert.True(t, errors.Is(err, c.err), "expected %v, got %v", c.err, err)
+		})
+	}
+}
+
 // skipWithoutGoogleCredentials skips the tests unless GCP credentials are present.
 func skipWithoutGoogleCredentials(t testing.TB) {
 	if os.Getenv("GOOGLE_APPLICATION_CREDENTIALS") == "" {
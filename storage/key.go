@@ -0,0 +1,20 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/tj/gobinaries"
+)
+
+// getKey returns the object key in the form `<prefix>/<pkg>/<binary>`.
+func getKey(prefix string, bin gobinaries.Binary) string {
+	dir := prefix + "/" + strings.Replace(bin.Path, "/", "-", -1)
+	file := fmt.Sprintf("%s-%s-%s", bin.Version, bin.OS, bin.Arch)
+	return dir + "/" + file
+}
+
+// getSidecarKey returns the key of a sidecar object in the form `<prefix>/<pkg>/<binary>.<name>`.
+func getSidecarKey(prefix string, bin gobinaries.Binary, name string) string {
+	return getKey(prefix, bin) + "." + name
+}
@@ -0,0 +1,181 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+
+	"github.com/tj/gobinaries"
+)
+
+// S3 is an S3-compatible object store for binaries, such as AWS S3 or MinIO.
+type S3 struct {
+	// Client is the S3 client.
+	Client *s3.Client
+
+	// Bucket is the bucket name.
+	Bucket string
+
+	// Prefix is an optional object key prefix.
+	Prefix string
+
+	// ForbiddenNotFound treats 403 responses to object downloads as missing
+	// objects, as AWS responds with 403 instead of 404 for missing keys when
+	// the credentials are not granted s3:ListBucket. Otherwise s3:ListBucket
+	// is required, so that missing binaries are built.
+	ForbiddenNotFound bool
+}
+
+// Create an object representing the package's binary.
+func (s *S3) Create(ctx context.Context, r io.Reader, bin gobinaries.Binary) error {
+	return s.put(ctx, r, getKey(s.Prefix, bin))
+}
+
+// Get returns an object.
+func (s *S3) Get(ctx context.Context, bin gobinaries.Binary) (io.ReadCloser, error) {
+	return s.get(ctx, getKey(s.Prefix, bin))
+}
+
+// CreateSidecar creates a sidecar object of the package's binary.
+func (s *S3) CreateSidecar(ctx context.Context, r io.Reader, bin gobinaries.Binary, name string) error {
+	return s.put(ctx, r, getSidecarKey(s.Prefix, bin, name))
+}
+
+// GetSidecar returns a sidecar object.
+func (s *S3) GetSidecar(ctx context.Context, bin gobinaries.Binary, name string) (io.ReadCloser, error) {
+	return s.get(ctx, getSidecarKey(s.Prefix, bin, name))
+}
+
+// put uploads the object of the given key.
+func (s *S3) put(ctx context.Context, r io.Reader, key string) error {
+	size, err := contentLength(r)
+
+	// spool to disk when the length is unknown,
+	// as S3 does not support chunked uploads
+	if err != nil {
+		f, err := ioutil.TempFile("", "gobinaries-s3")
+		if err != nil {
+			return fmt.Errorf("creating tempfile: %w", err)
+		}
+		defer os.Remove(f.Name())
+		defer f.Close()
+
+		size, err = io.Copy(f, r)
+		if err != nil {
+			return fmt.Errorf("spooling: %w", err)
+		}
+
+		_, err = f.Seek(0, io.SeekStart)
+		if err != nil {
+			return fmt.Errorf("seeking: %w", err)
+		}
+
+		r = f
+	}
+
+	// the payload is not signed, so that readers which
+	// cannot be seeked are not read twice to hash them
+	_, err = s.Client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:        &s.Bucket,
+		Key:           &key,
+		Body:          r,
+		ContentLength: size,
+		ContentType:   aws.String("application/octet-stream"),
+	}, s3.WithAPIOptions(v4.SwapComputePayloadSHA256ForUnsignedPayloadMiddleware))
+
+	if err != nil {
+		return fmt.Errorf("putting object: %w", normalizeS3Error(err))
+	}
+
+	return nil
+}
+
+// get downloads the object of the given key.
+func (s *S3) get(ctx context.Context, key string) (io.ReadCloser, error) {
+	res, err := s.Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: &s.Bucket,
+		Key:    &key,
+	})
+
+	err = normalizeS3Error(err)
+	if errors.Is(err, gobinaries.ErrStorageForbidden) && s.ForbiddenNotFound {
+		return nil, gobinaries.ErrObjectNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return res.Body, nil
+}
+
+// sizedReader is a reader of a known length, such as an
+// object read from a file while it is also being cached.
+type sizedReader struct {
+	io.Reader
+	size int64
+}
+
+// contentLength returns the length of r when it is known without reading it.
+func contentLength(r io.Reader) (int64, error) {
+	switch v := r.(type) {
+	case *sizedReader:
+		return v.size, nil
+	case *os.File:
+		info, err := v.Stat()
+		if err != nil {
+			return 0, err
+		}
+
+		offset, err := v.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return 0, err
+		}
+
+		return info.Size() - offset, nil
+	case interface{ Len() int }:
+		return int64(v.Len()), nil
+	default:
+		return 0, errors.New("unknown length")
+	}
+}
+
+// normalizeS3Error returns a typed error for missing objects, transient
+// failures and permission failures, or err when it is unknown.
+func normalizeS3Error(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return fmt.Errorf("%w: %s", gobinaries.ErrStorageUnavailable, err)
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return fmt.Errorf("%w: %s", gobinaries.ErrStorageUnavailable, err)
+	}
+
+	var resErr interface{ HTTPStatusCode() int }
+	if errors.As(err, &resErr) {
+		switch code := resErr.HTTPStatusCode(); {
+		case code == http.StatusNotFound:
+			return gobinaries.ErrObjectNotFound
+		case code == http.StatusUnauthorized || code == http.StatusForbidden:
+			return fmt.Errorf("%w: %s", gobinaries.ErrStorageForbidden, err)
+		case code == http.StatusTooManyRequests || code >= 500:
+			return fmt.Errorf("%w: %s", gobinaries.ErrStorageUnavailable, err)
+		}
+	}
+
+	return err
+}
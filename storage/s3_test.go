@@ -0,0 +1,250 @@
+package storage_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/tj/assert"
+
+	"github.com/tj/gobinaries"
+	"github.com/tj/gobinaries/storage"
+)
+
+// fakeS3 is a minimal in-memory S3 server.
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	hosts   []string
+	tokens  []string
+
+	// forbidMissing responds with 403 for missing objects, as AWS
+	// does when the credentials are not granted s3:ListBucket.
+	forbidMissing bool
+}
+
+// ServeHTTP implementation.
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=access/") {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	f.hosts = append(f.hosts, r.Host)
+	f.tokens = append(f.tokens, r.Header.Get("X-Amz-Security-Token"))
+	key := r.Host + r.URL.EscapedPath()
+
+	switch r.Method {
+	case "PUT":
+		if r.ContentLength < 0 {
+			w.WriteHeader(http.StatusLengthRequired)
+			return
+		}
+
+		b, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		f.objects[key] = b
+	case "GET":
+		b, ok := f.objects[key]
+		if !ok && f.forbidMissing {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		w.Write(b)
+	}
+}
+
+// newS3Client returns an S3 client of the given endpoint.
+func newS3Client(endpoint string, pathStyle bool, creds aws.CredentialsProvider, client *http.Client) *s3.Client {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	return s3.New(s3.Options{
+		Region:           "us-east-1",
+		Credentials:      creds,
+		EndpointResolver: s3.EndpointResolverFromURL(endpoint),
+		UsePathStyle:     pathStyle,
+		HTTPClient:       client,
+	})
+}
+
+// Test S3 storage.
+func TestS3(t *testing.T) {
+	fake := &fakeS3{objects: make(map[string][]byte)}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	ctx := context.Background()
+	creds := credentials.NewStaticCredentialsProvider("access", "secret", "")
+
+	bin := gobinaries.Binary{
+		Path:    "github.com/tj/node-prune",
+		Version: "v1.0.0+incompatible",
+		OS:      "darwin",
+		Arch:    "amd64",
+	}
+
+	t.Run("path-style", func(t *testing.T) {
+		s := &storage.S3{
+			Client: newS3Client(srv.URL, true, creds, nil),
+			Bucket: "gobinaries",
+			Prefix: "testing",
+		}
+
+		_, err := s.Get(ctx, bin)
+		assert.Equal(t, gobinaries.ErrObjectNotFound, err)
+
+		err = s.Create(ctx, strings.NewReader("Hello World"), bin)
+		assert.NoError(t, err)
+
+		host := strings.TrimPrefix(srv.URL, "http://")
+		_, ok := fake.objects[host+"/gobinaries/testing/github.com-tj-node-prune/v1.0.0%2Bincompatible-darwin-amd64"]
+		assert.True(t, ok, "object should be stored by key")
+
+		r, err := s.Get(ctx, bin)
+		assert.NoError(t, err)
+		defer r.Close()
+
+		b, err := ioutil.ReadAll(r)
+		assert.NoError(t, err)
+		assert.Equal(t, "Hello World", string(b))
+
+		err = s.CreateSidecar(ctx, strings.NewReader("abc123"), bin, "sha256")
+		assert.NoError(t, err)
+
+		_, ok = fake.objects[host+"/gobinaries/testing/github.com-tj-node-prune/v1.0.0%2Bincompatible-darwin-amd64.sha256"]
+		assert.True(t, ok, "sidecar should be stored next to the object")
+
+		sidecar, err := s.GetSidecar(ctx, bin, "sha256")
+		assert.NoError(t, err)
+		defer sidecar.Close()
+
+		b, err = ioutil.ReadAll(sidecar)
+		assert.NoError(t, err)
+		assert.Equal(t, "abc123", string(b))
+	})
+
+	t.Run("virtual-hosted", func(t *testing.T) {
+		client := &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+					return net.Dial(network, srv.Listener.Addr().String())
+				},
+			},
+		}
+
+		s := &storage.S3{
+			Client: newS3Client("http://s3.example.com", false, creds, client),
+			Bucket: "gobinaries",
+			Prefix: "testing",
+		}
+
+		// unknown length
+		r := ioutil.NopCloser(strings.NewReader("Hello World"))
+		err := s.Create(ctx, r, bin)
+		assert.NoError(t, err)
+
+		obj, err := s.Get(ctx, bin)
+		assert.NoError(t, err)
+		defer obj.Close()
+
+		b, err := ioutil.ReadAll(obj)
+		assert.NoError(t, err)
+		assert.Equal(t, "Hello World", string(b))
+		assert.Equal(t, "gobinaries.s3.example.com", fake.hosts[len(fake.hosts)-1])
+	})
+
+	t.Run("forbidden", func(t *testing.T) {
+		s := &storage.S3{
+			Client: newS3Client(srv.URL, true, aws.AnonymousCredentials{}, nil),
+			Bucket: "gobinaries",
+		}
+
+		_, err := s.Get(ctx, bin)
+		assert.True(t, errors.Is(err, gobinaries.ErrStorageForbidden))
+	})
+
+	t.Run("forbidden missing objects", func(t *testing.T) {
+		fake.mu.Lock()
+		fake.forbidMissing = true
+		fake.mu.Unlock()
+		defer func() {
+			fake.mu.Lock()
+			fake.forbidMissing = false
+			fake.mu.Unlock()
+		}()
+
+		missing := bin
+		missing.Version = "v2.0.0"
+
+		s := &storage.S3{
+			Client: newS3Client(srv.URL, true, creds, nil),
+			Bucket: "gobinaries",
+		}
+
+		_, err := s.Get(ctx, missing)
+		assert.True(t, errors.Is(err, gobinaries.ErrStorageForbidden))
+
+		s.ForbiddenNotFound = true
+		_, err = s.Get(ctx, missing)
+		assert.Equal(t, gobinaries.ErrObjectNotFound, err)
+
+		_, err = s.GetSidecar(ctx, missing, "sha256")
+		assert.Equal(t, gobinaries.ErrObjectNotFound, err)
+	})
+
+	t.Run("session token", func(t *testing.T) {
+		s := &storage.S3{
+			Client: newS3Client(srv.URL, true, credentials.NewStaticCredentialsProvider("access", "secret", "session"), nil),
+			Bucket: "gobinaries",
+		}
+
+		err := s.Create(ctx, strings.NewReader("Hello World"), bin)
+		assert.NoError(t, err)
+		assert.Equal(t, "session", fake.tokens[len(fake.tokens)-1])
+	})
+
+	t.Run("cached without spooling", func(t *testing.T) {
+		// spooling fails without a temporary directory
+		tmp := os.Getenv("TMPDIR")
+		os.Setenv("TMPDIR", filepath.Join(os.TempDir(), "gobinaries-missing"))
+		defer os.Setenv("TMPDIR", tmp)
+
+		s := &storage.Cache{
+			Storage: &storage.S3{
+				Client: newS3Client(srv.URL, true, creds, nil),
+				Bucket: "gobinaries",
+			},
+			MaxSize: 1 << 20,
+		}
+
+		err := s.Create(ctx, strings.NewReader("Hello World"), bin)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, s.Stats().Objects)
+	})
+}
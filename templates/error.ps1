@@ -0,0 +1,4 @@
+Write-Host ""
+Write-Host "  Error: " -ForegroundColor Red -NoNewline
+Write-Host '{{escapePowerShell .}}'
+Write-Host ""
\ No newline at end of file
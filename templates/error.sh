@@ -1,3 +1,4 @@
+#!/bin/sh
 echo
 printf "  \033[38;5;125mError:\033[0;00m {{.}}\n"
 echo
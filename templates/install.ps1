@@ -0,0 +1,170 @@
+# PowerShell installer for Windows, run with:
+#
+#   iwr -useb https://gobinaries.com/<package>.ps1 | iex
+#
+# The script runs within its own scope so that it does not
+# leave variables or functions behind in the user's session.
+
+& {
+  $ErrorActionPreference = "Stop"
+
+  function log_info($msg) {
+    Write-Host "  ==> " -ForegroundColor Blue -NoNewline
+    Write-Host $msg
+  }
+
+  function log_warn($msg) {
+    Write-Host "  ==> " -ForegroundColor Yellow -NoNewline
+    Write-Host $msg
+  }
+
+  function log_crit($msg) {
+    Write-Host ""
+    Write-Host "  $msg" -ForegroundColor Red
+    Write-Host ""
+  }
+
+  function uname_arch {
+    # PROCESSOR_ARCHITEW6432 is set for 32-bit processes on 64-bit Windows
+    $arch = $env:PROCESSOR_ARCHITEW6432
+    if (-not $arch) {
+      $arch = $env:PROCESSOR_ARCHITECTURE
+    }
+
+    switch ($arch) {
+      "AMD64" { return "amd64" }
+      "ARM64" { return "arm64" }
+      "x86" { return "386" }
+    }
+  }
+
+  $arch = uname_arch
+  if (-not $arch) {
+    log_crit "Processor architecture '$env:PROCESSOR_ARCHITECTURE' is not supported"
+    return
+  }
+
+  # API endpoint such as "http://localhost:3000"
+  $api = '{{escapePowerShell .URL}}'
+
+  # package such as "github.com/tj/triage/cmd/triage"
+  $pkg = '{{escapePowerShell .Package}}'
+
+  # binary name such as "hello.exe"
+  $bin = '{{escapePowerShell .Binary}}.exe'
+
+  # original_version such as "latest" or "master"
+  $original_version = '{{escapePowerShell .OriginalVersion}}'
+
+  # module such as "github.com/tj/triage"
+  $module = '{{escapePowerShell .Module}}'
+
+  # version such as "v1.2.0" or "v0.0.0-20200101120000-abcdef123456"
+  $version = '{{escapePowerShell .Version}}'
+
+  # commit such as "abcdef123456" when a branch or commit was requested
+  $commit = '{{escapePowerShell .Commit}}'
+
+  # deprecated is the module's deprecation message, if any
+  $deprecated = '{{escapePowerShell .Deprecated}}'
+
+  # query string of the binary for this machine
+  $query = '?os=windows&arch=' + $arch + '&version={{urlquery .Version}}&module={{urlquery .Module}}'
+
+  $prefix = $env:PREFIX
+  if (-not $prefix) {
+    $prefix = Join-Path $env:LOCALAPPDATA "Programs\gobinaries"
+  }
+
+  # public_key is an optional PEM file of the server's signing key
+  $public_key = $env:PUBLIC_KEY
+
+  # headers sent for private packages, when the
+  # optional GOBINARIES_TOKEN API token is set
+  $headers = @{}
+  if ($env:GOBINARIES_TOKEN) {
+    $headers["Authorization"] = "Bearer $env:GOBINARIES_TOKEN"
+  }
+
+  $tmp = Join-Path ([IO.Path]::GetTempPath()) ([IO.Path]::GetRandomFileName())
+
+  # older versions of PowerShell default to TLS 1.0
+  [Net.ServicePointManager]::SecurityProtocol = [Net.ServicePointManager]::SecurityProtocol -bor [Net.SecurityProtocolType]::Tls12
+
+  Write-Host ""
+  log_info "Downloading ${pkg}@${original_version}"
+  if ($commit) {
+    log_info "Resolved $original_version to commit $commit ($version)"
+  } elseif ($original_version -ne $version) {
+    log_info "Resolved version $original_version to $version"
+  }
+  if ($deprecated) {
+    log_warn "Module is deprecated: $deprecated"
+  }
+  log_info "Downloading binary for windows $arch"
+  try {
+    Invoke-WebRequest -UseBasicParsing -Uri "$api/binary/${pkg}${query}" -Headers $headers -OutFile $tmp
+  } catch {
+    log_crit "Error downloading, $($_.Exception.Message)"
+    return
+  }
+
+  log_info "Verifying checksum"
+  try {
+    $checksum = (Invoke-WebRequest -UseBasicParsing -Uri "$api/checksum/${pkg}${query}" -Headers $headers).Content
+  } catch {
+    log_crit "Error downloading checksum, $($_.Exception.Message)"
+    Remove-Item -Force $tmp
+    return
+  }
+
+  $expected = ([string]$checksum -split "\s+")[0]
+  $actual = (Get-FileHash -Algorithm SHA256 -Path $tmp).Hash.ToLower()
+  if ($actual -ne $expected) {
+    log_crit "Checksum mismatch, expected $expected but got $actual"
+    Remove-Item -Force $tmp
+    return
+  }
+
+  if ($public_key) {
+    log_info "Verifying signature"
+    if (-not (Get-Command openssl -ErrorAction SilentlyContinue)) {
+      log_crit "openssl is required to verify the signature"
+      Remove-Item -Force $tmp
+      return
+    }
+
+    try {
+      $signature = (Invoke-WebRequest -UseBasicParsing -Uri "$api/signature/${pkg}${query}" -Headers $headers).Content
+    } catch {
+      log_crit "Error downloading signature, $($_.Exception.Message)"
+      Remove-Item -Force $tmp
+      return
+    }
+
+    # the message binding the checksum to the package, module, version and platform
+    $msg = "gobinaries-signature-v1`npath $pkg`nmodule $module`nversion $version`nos windows`narch $arch`nsha256 $actual`n"
+    [IO.File]::WriteAllBytes("$tmp.msg", [Text.Encoding]::UTF8.GetBytes($msg))
+    [IO.File]::WriteAllBytes("$tmp.sig", [Convert]::FromBase64String(([string]$signature).Trim()))
+
+    & openssl pkeyutl -verify -pubin -inkey $public_key -rawin -in "$tmp.msg" -sigfile "$tmp.sig" *> $null
+    $verified = $LASTEXITCODE -eq 0
+    Remove-Item -Force "$tmp.msg", "$tmp.sig"
+    if (-not $verified) {
+      log_crit "Signature verification failed with public key $public_key"
+      Remove-Item -Force $tmp
+      return
+    }
+  }
+
+  log_info "Installing $bin to $prefix"
+  New-Item -ItemType Directory -Force -Path $prefix | Out-Null
+  Move-Item -Force -Path $tmp -Destination (Join-Path $prefix $bin)
+
+  if (($env:Path -split ";") -notcontains $prefix) {
+    log_warn "Add $prefix to your PATH in order to run $bin"
+  }
+
+  log_info "Installation complete"
+  Write-Host ""
+}
@@ -12,6 +12,10 @@ log_info() {
   printf "\033[38;5;61m  ==>\033[0;00m $@\n"
 }
 
+log_warn() {
+  printf "\033[38;5;178m  ==>\033[0;00m $@\n"
+}
+
 log_crit() {
   echoerr
   echoerr "  \033[38;5;125m$@\033[0;00m"
@@ -33,6 +37,11 @@ http_download_curl() {
     code=$(curl -w '%{http_code}' -sL -H "$header" -o "$local_file" "$source_url")
   fi
   if [ "$code" != "200" ]; then
+    # known errors such as unsupported platforms respond with an error script
+    if [ "$(head -n 1 "$local_file" 2>/dev/null)" = "#!/bin/sh" ]; then
+      sh "$local_file"
+      exit 1
+    fi
     log_crit "Error downloading, got $code response from server"
     return 1
   fi
@@ -139,6 +148,44 @@ uname_arch_check() {
   return 1
 }
 
+sha256_file() {
+  if is_command sha256sum; then
+    sha256sum "$1" | cut -d ' ' -f 1
+  elif is_command shasum; then
+    shasum -a 256 "$1" | cut -d ' ' -f 1
+  else
+    log_crit "sha256sum or shasum is required to verify the binary checksum"
+    return 1
+  fi
+}
+
+verify_checksum() {
+  file=$1
+  expected=$(cut -d ' ' -f 1 "$2")
+  actual=$(sha256_file "$file")
+  if [ "$actual" != "$expected" ]; then
+    log_crit "Checksum mismatch, expected $expected but got $actual"
+    return 1
+  fi
+}
+
+verify_signature() {
+  checksum=$1
+  signature=$2
+  key=$3
+  if ! is_command openssl; then
+    log_crit "openssl is required to verify the signature"
+    return 1
+  fi
+  printf 'gobinaries-signature-v1\npath %s\nmodule %s\nversion %s\nos %s\narch %s\nsha256 %s\n' \
+    "$pkg" "$module" "$version" "$os" "$arch" "$(cut -d ' ' -f 1 "$checksum")" > "$checksum.msg"
+  openssl base64 -d -A -in "$signature" -out "$signature.bin"
+  if ! openssl pkeyutl -verify -pubin -inkey "$key" -rawin -in "$checksum.msg" -sigfile "$signature.bin" >/dev/null 2>&1; then
+    log_crit "Signature verification failed with public key $key"
+    return 1
+  fi
+}
+
 mktmpdir() {
   test -z "$TMPDIR" && TMPDIR="$(mktemp -d)"
   mkdir -p "${TMPDIR}"
@@ -155,25 +202,65 @@ start() {
   # package such as "github.com/tj/triage/cmd/triage"
   pkg="{{.Package}}"
 
-  # binary name such as "hello"
+  # binary name such as "hello", or "hello.exe" for windows
   bin="{{.Binary}}"
+  if [ "$os" = "windows" ]; then
+    bin="$bin.exe"
+  fi
 
-  # original_version such as "master"
+  # original_version such as "latest" or "master"
   original_version="{{.OriginalVersion}}"
 
-  # version such as "master"
+  # module such as "github.com/tj/triage"
+  module="{{.Module}}"
+
+  # version such as "v1.2.0" or "v0.0.0-20200101120000-abcdef123456"
   version="{{.Version}}"
+
+  # commit such as "abcdef123456" when a branch or commit was requested
+  commit="{{.Commit}}"
+
+  # deprecated is the module's deprecation message, if any
+  deprecated='{{escapeMessage .Deprecated}}'
+
+  # query string of the binary for this machine
+  query="?os=$os&arch=$arch&version={{urlquery .Version}}&module={{urlquery .Module}}"
   
   prefix=${PREFIX:-"/usr/local/bin"}
+
+  # public_key is an optional PEM file of the server's signing key
+  public_key=${PUBLIC_KEY:-""}
+
+  # auth is the Authorization header field sent for private packages,
+  # when the optional GOBINARIES_TOKEN API token is set
+  auth=""
+  if [ -n "${GOBINARIES_TOKEN:-}" ]; then
+    auth="Authorization: Bearer $GOBINARIES_TOKEN"
+  fi
   tmp="$(mktmpdir)/$bin"
 
   echo
   log_info "Downloading $pkg@$original_version"
-  if [ "$original_version" != "$version" ]; then
+  if [ -n "$commit" ]; then
+    log_info "Resolved $original_version to commit $commit ($version)"
+  elif [ "$original_version" != "$version" ]; then
     log_info "Resolved version $original_version to $version"
   fi
+  if [ -n "$deprecated" ]; then
+    log_warn "Module is deprecated: $deprecated"
+  fi
   log_info "Downloading binary for $os $arch"
-  http_download $tmp "$api/binary/$pkg?os=$os&arch=$arch&version=$version"
+  http_download "$tmp" "$api/binary/$pkg$query" "$auth"
+
+  log_info "Verifying checksum"
+  http_download "$tmp.sha256" "$api/checksum/$pkg$query" "$auth"
+  verify_checksum "$tmp" "$tmp.sha256"
+
+  if [ -n "$public_key" ]; then
+    log_info "Verifying signature"
+    http_download "$tmp.sig" "$api/signature/$pkg$query" "$auth"
+    verify_signature "$tmp.sha256" "$tmp.sig" "$public_key"
+  fi
 
   if [ -w "$prefix" ]; then
   log_info "Installing $bin to $prefix"